@@ -49,7 +49,19 @@ socket.addEventListener('message', function (event) {
     console.log('Message from server ', JSON.parse(event.data));
 });
 
+// raw json mode
+const socket = new WebSocket('ws://localhost:5000/', 'ws-gateway.json');
+socket.addEventListener('open', function (event) {
+    socket.send('{"member_id": 123456, "token": "654321"}');
+    socket.send('{"app": "match"}')
+    socket.send('{"app": "im"}')
+});
+socket.addEventListener('message', function (event) {
+    console.log('Message from server ', JSON.parse(event.data).data);
+});
+
 
 // test for httpie
-// http POST http://localhost:5000/push app=match member_id:=-1 text='{"hello":"world"}'
-// http POST http://localhost:5000/push app=im member_id:=123456 text='{"hello":123456}'
\ No newline at end of file
+// http POST http://127.0.0.1:5001/push app=match member_id:=-1 text='{"hello":"world"}'
+// http POST http://127.0.0.1:5001/push app=im member_id:=123456 text='{"hello":123456}'
+// http POST http://127.0.0.1:5001/push app=match member_id:=-1 data:='{"hello":"world"}'
\ No newline at end of file
@@ -0,0 +1,135 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// AppVisibility decides who can subscribe an app and how messages are routed
+type AppVisibility string
+
+const (
+	// PublicApp anyone can subscribe, messages are broadcast to all subscribers
+	PublicApp AppVisibility = "public"
+	// AuthenticatedApp authenticated members can subscribe, messages are broadcast to all subscribers
+	AuthenticatedApp AppVisibility = "authenticated"
+	// PrivateApp authenticated members can subscribe, messages are routed by member id
+	PrivateApp AppVisibility = "private"
+)
+
+func (v AppVisibility) valid() bool {
+	switch v {
+	case PublicApp, AuthenticatedApp, PrivateApp:
+		return true
+	}
+	return false
+}
+
+// AppConfig app definition, HistorySize recent push messages of app are
+// kept for replay on subscribe, HistoryRetention limits their age when set
+type AppConfig struct {
+	Name             string        `json:"name"`
+	Visibility       AppVisibility `json:"visibility"`
+	HistorySize      int           `json:"history_size,omitempty"`
+	HistoryRetention time.Duration `json:"-"`
+}
+
+// UnmarshalJSON parse app definition, history_retention is a duration like "10m"
+func (c *AppConfig) UnmarshalJSON(data []byte) error {
+	type appConfig AppConfig
+	var config struct {
+		appConfig
+		HistoryRetention string `json:"history_retention"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+
+	*c = AppConfig(config.appConfig)
+	if config.HistoryRetention != "" {
+		retention, err := time.ParseDuration(config.HistoryRetention)
+		if err != nil {
+			return fmt.Errorf("bad history_retention of app %s: %v", c.Name, err)
+		}
+		c.HistoryRetention = retention
+	}
+	return nil
+}
+
+// AppRegistry app definitions, apps not registered are public
+type AppRegistry struct {
+	mu   sync.RWMutex
+	apps map[string]AppConfig
+}
+
+// NewAppRegistry create a new AppRegistry
+func NewAppRegistry(apps ...AppConfig) *AppRegistry {
+	registry := &AppRegistry{
+		apps: make(map[string]AppConfig),
+	}
+	registry.Register(apps...)
+	return registry
+}
+
+// Register add or replace app definitions
+func (r *AppRegistry) Register(apps ...AppConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, app := range apps {
+		r.apps[app.Name] = app
+	}
+}
+
+// App returns definition of app
+func (r *AppRegistry) App(name string) AppConfig {
+	r.mu.RLock()
+	app, ok := r.apps[name]
+	r.mu.RUnlock()
+	if !ok {
+		return AppConfig{Name: name, Visibility: PublicApp}
+	}
+	return app
+}
+
+func (r *AppRegistry) isPrivate(app string) bool {
+	return r.App(app).Visibility == PrivateApp
+}
+
+func (r *AppRegistry) isAuthenticated(app string) bool {
+	return r.App(app).Visibility == AuthenticatedApp
+}
+
+// DefaultAppRegistry create a new AppRegistry of servers and stores created
+// without one, im is private and other apps are public
+func DefaultAppRegistry() *AppRegistry {
+	return NewAppRegistry(AppConfig{Name: "im", Visibility: PrivateApp})
+}
+
+// LoadApps load app definitions from JSON file like
+// {"apps":[{"name":"im","visibility":"private"},{"name":"match","history_size":100,"history_retention":"10m"}]}
+func LoadApps(path string) ([]AppConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read apps file failed: %v", err)
+	}
+
+	var config struct {
+		Apps []AppConfig `json:"apps"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parse apps file failed: %v", err)
+	}
+
+	for _, app := range config.Apps {
+		if app.Name == "" {
+			return nil, fmt.Errorf("app name is empty")
+		}
+		if !app.Visibility.valid() {
+			return nil, fmt.Errorf("app %s has unknown visibility %q", app.Name, app.Visibility)
+		}
+	}
+	return config.Apps, nil
+}
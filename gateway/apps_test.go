@@ -0,0 +1,150 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAppRegistry(t *testing.T) {
+	registry := NewAppRegistry(AppConfig{Name: imApp, Visibility: PrivateApp})
+	assertEqual(t, registry.App(imApp).Visibility, PrivateApp)
+	assertEqual(t, registry.App("match").Visibility, PublicApp)
+
+	registry.Register(AppConfig{Name: "match", Visibility: AuthenticatedApp})
+	assertEqual(t, registry.App("match").Visibility, AuthenticatedApp)
+}
+
+func TestDefaultAppRegistryNotShared(t *testing.T) {
+	registry := DefaultAppRegistry()
+	assertEqual(t, registry.App(imApp).Visibility, PrivateApp)
+
+	registry.Register(AppConfig{Name: "match", Visibility: PrivateApp})
+	assertEqual(t, DefaultAppRegistry().App("match").Visibility, PublicApp)
+}
+
+func TestLoadApps(t *testing.T) {
+	dir := mustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	t.Run("valid apps file", func(t *testing.T) {
+		path := mustWriteFile(t, dir, "apps.json", []byte(`{"apps":[{"name":"notify","visibility":"private"},{"name":"vip","visibility":"authenticated"}]}`))
+		apps, err := LoadApps(path)
+		assertNoError(t, err)
+		assertEqual(t, apps, []AppConfig{{Name: "notify", Visibility: PrivateApp}, {Name: "vip", Visibility: AuthenticatedApp}})
+	})
+
+	t.Run("history", func(t *testing.T) {
+		path := mustWriteFile(t, dir, "history-apps.json", []byte(`{"apps":[{"name":"match","visibility":"public","history_size":100,"history_retention":"10m"}]}`))
+		apps, err := LoadApps(path)
+		assertNoError(t, err)
+		assertEqual(t, apps, []AppConfig{{Name: "match", Visibility: PublicApp, HistorySize: 100, HistoryRetention: time.Minute * 10}})
+	})
+
+	t.Run("bad history retention", func(t *testing.T) {
+		path := mustWriteFile(t, dir, "bad-history-apps.json", []byte(`{"apps":[{"name":"match","visibility":"public","history_size":100,"history_retention":"soon"}]}`))
+		_, err := LoadApps(path)
+		assertError(t, err)
+	})
+
+	t.Run("unknown visibility", func(t *testing.T) {
+		path := mustWriteFile(t, dir, "bad-apps.json", []byte(`{"apps":[{"name":"notify","visibility":"secret"}]}`))
+		_, err := LoadApps(path)
+		assertError(t, err)
+	})
+}
+
+func TestConfiguredApps(t *testing.T) {
+	privateApp := "notify"
+	authenticatedApp := "vip"
+	registry := NewAppRegistry(
+		AppConfig{Name: privateApp, Visibility: PrivateApp},
+		AppConfig{Name: authenticatedApp, Visibility: AuthenticatedApp},
+	)
+
+	store := NewInMemeryWSClientStoreForApps(registry)
+	gateway := NewGatewayServer(store, &FakeAuthServer{}, WithAppRegistry(registry))
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	t.Run("anonymous can not subscribe authenticated or private app", func(t *testing.T) {
+		ws, _ := mustConnectTo(t, server)
+		defer ws.Close()
+		mustSendAuthMessage(t, ws, anonymousMemberID, "")
+		mustReadMessageWithTimeout(t, ws, time.Millisecond*10)
+
+		for _, app := range []string{privateApp, authenticatedApp} {
+			mustSendSubscribeMessage(t, ws, app)
+			msg := mustReadMessageWithTimeout(t, ws, time.Millisecond*10)
+			assertMessage(t, msg, subscribeForbiddenMessageForApp(app))
+		}
+	})
+
+	t.Run("push to private app is routed by member", func(t *testing.T) {
+		memberID1 := 123456
+		memberID2 := 654321
+		ws1 := mustConnectAndAuthAndSubscribe(t, server, memberID1, "token", privateApp)
+		defer ws1.Close()
+		ws2 := mustConnectAndAuthAndSubscribe(t, server, memberID2, "token", privateApp)
+		defer ws2.Close()
+
+		assertWSClientCount(t, len(store.privateWSClientsForMember(privateApp, memberID1)), 1)
+		assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, memberID1)), 0)
+
+		pushText := fmt.Sprintf(`{"hello":"%d"}`, memberID1)
+		response := httptest.NewRecorder()
+		gateway.ServeHTTP(response, newPushMessagePostRequest(privateApp, memberID1, pushText))
+
+		msg := mustReadMessageWithTimeout(t, ws1, time.Millisecond*10)
+		assertMessage(t, msg, string(pushMessageJSONFor(privateApp, memberID1, pushText)))
+		_, err := readMessageWithTimeout(ws2, time.Millisecond*10)
+		assertError(t, err)
+	})
+
+	t.Run("push to authenticated app is broadcast", func(t *testing.T) {
+		ws1 := mustConnectAndAuthAndSubscribe(t, server, 123456, "token", authenticatedApp)
+		defer ws1.Close()
+		ws2 := mustConnectAndAuthAndSubscribe(t, server, 654321, "token", authenticatedApp)
+		defer ws2.Close()
+
+		pushText := `{"hello":"vip"}`
+		response := httptest.NewRecorder()
+		gateway.ServeHTTP(response, newPushMessagePostRequest(authenticatedApp, anonymousMemberID, pushText))
+
+		assertMessage(t, mustReadMessageWithTimeout(t, ws1, time.Millisecond*10), string(pushMessageJSONFor(authenticatedApp, anonymousMemberID, pushText)))
+		assertMessage(t, mustReadMessageWithTimeout(t, ws2, time.Millisecond*10), string(pushMessageJSONFor(authenticatedApp, anonymousMemberID, pushText)))
+	})
+}
+
+func TestServersWithOwnAppRegistry(t *testing.T) {
+	registry := NewAppRegistry(AppConfig{Name: "notify", Visibility: PrivateApp})
+	privateGateway := NewGatewayServer(NewInMemeryWSClientStoreForApps(registry), &FakeAuthServer{},
+		WithAppRegistry(registry))
+	privateServer := httptest.NewServer(privateGateway)
+	defer privateServer.Close()
+	publicServer, _ := newServer()
+	defer publicServer.Close()
+
+	tests := []struct {
+		description string
+		server      *httptest.Server
+		want        string
+	}{
+		{"private in own registry", privateServer, subscribeForbiddenMessageForApp("notify")},
+		{"public in default registry", publicServer, subscribeSuccessMessageForApp("notify")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			ws, _ := mustConnectTo(t, tt.server)
+			defer ws.Close()
+			mustSendAuthMessage(t, ws, anonymousMemberID, "")
+			mustReadMessageWithTimeout(t, ws, time.Millisecond*10)
+
+			mustSendSubscribeMessage(t, ws, "notify")
+			assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10), tt.want)
+		})
+	}
+}
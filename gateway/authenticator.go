@@ -0,0 +1,58 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrUnauthorized client credentials rejected by auth backend
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrAuthUnavailable auth backend can not make a decision
+	ErrAuthUnavailable = errors.New("auth unavailable")
+)
+
+// Identity authenticated client identity
+type Identity struct {
+	MemberID int
+	// Apps apps member can subscribe, nil means any app
+	Apps []string
+	// ExpiresAt identity expire time, zero means never expire
+	ExpiresAt time.Time
+}
+
+var anonymousIdentity = Identity{MemberID: anonymousMemberID}
+
+// Authenticator client authenticator interface,
+// Authenticate returns ErrUnauthorized when credentials are rejected
+// and ErrAuthUnavailable when auth backend is down
+type Authenticator interface {
+	Authenticate(ctx context.Context, auth AuthMessage) (Identity, error)
+}
+
+// authServerAuthenticator adapts AuthServer to Authenticator
+type authServerAuthenticator struct {
+	authServer AuthServer
+}
+
+// NewAuthServerAuthenticator create a Authenticator from AuthServer
+func NewAuthServerAuthenticator(authServer AuthServer) Authenticator {
+	return &authServerAuthenticator{authServer: authServer}
+}
+
+// Authenticate check member by AuthServer
+func (a *authServerAuthenticator) Authenticate(ctx context.Context, auth AuthMessage) (Identity, error) {
+	if !a.authServer.Auth(auth.MemberID, auth.Token) {
+		return Identity{}, ErrUnauthorized
+	}
+	return Identity{MemberID: auth.MemberID}, nil
+}
+
+// authenticatorFor returns authServer itself if it implements Authenticator
+func authenticatorFor(authServer AuthServer) Authenticator {
+	if a, ok := authServer.(Authenticator); ok {
+		return a
+	}
+	return NewAuthServerAuthenticator(authServer)
+}
@@ -0,0 +1,72 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthServerAuthenticator(t *testing.T) {
+	authenticator := NewAuthServerAuthenticator(&FakeAuthServer{})
+
+	identity, err := authenticator.Authenticate(context.Background(), AuthMessage{MemberID: 123456, Token: "654321"})
+	assertNoError(t, err)
+	assertEqual(t, identity.MemberID, 123456)
+
+	_, err = authenticator.Authenticate(context.Background(), AuthMessage{MemberID: 12345, Token: "654321"})
+	assertEqual(t, err, ErrUnauthorized)
+}
+
+func TestAuthUnavailable(t *testing.T) {
+	memberService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer memberService.Close()
+
+	authServer := NewHTTPAuthServer(HTTPAuthServerConfig{URL: memberService.URL})
+	server := httptest.NewServer(NewGatewayServer(NewInMemeryWSClientStore(), authServer))
+	defer server.Close()
+
+	ws, _ := mustConnectTo(t, server)
+	defer ws.Close()
+	mustSendAuthMessage(t, ws, 123456, "654321")
+
+	msg := mustReadMessageWithTimeout(t, ws, time.Millisecond*100)
+	assertMessage(t, msg, authUnavailableMessage())
+
+	_, err := readMessageWithTimeout(ws, time.Millisecond*10)
+	assertError(t, err)
+}
+
+type blockingAuthServer struct {
+	canceled chan struct{}
+}
+
+func (s *blockingAuthServer) Auth(memberID int, token string) bool {
+	return false
+}
+
+func (s *blockingAuthServer) Authenticate(ctx context.Context, auth AuthMessage) (Identity, error) {
+	<-ctx.Done()
+	close(s.canceled)
+	return Identity{}, ErrAuthUnavailable
+}
+
+func TestAuthCanceledWhenClientDisconnected(t *testing.T) {
+	authServer := &blockingAuthServer{canceled: make(chan struct{})}
+	server := httptest.NewServer(NewGatewayServer(NewInMemeryWSClientStore(), authServer))
+	defer server.Close()
+
+	ws, _ := mustConnectTo(t, server)
+	mustSendAuthMessage(t, ws, 123456, "654321")
+	time.Sleep(time.Millisecond * 10)
+	ws.Close()
+
+	select {
+	case <-authServer.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("auth was not canceled after client disconnected")
+	}
+}
@@ -0,0 +1,29 @@
+package gateway
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	connIDSeq  uint64
+	connIDNode = newConnIDNode()
+)
+
+// newConnIDNode random node part of connection id, so ids of gateway
+// instances do not collide
+func newConnIDNode() string {
+	node := make([]byte, 2)
+	rand.Read(node)
+	return hex.EncodeToString(node)
+}
+
+// newConnID returns unique connection id sortable by creation time, which is
+// creation unix milliseconds, node and sequence of connection in hex
+func newConnID() string {
+	seq := atomic.AddUint64(&connIDSeq, 1)
+	return fmt.Sprintf("%012x-%s-%08x", time.Now().UnixNano()/int64(time.Millisecond), connIDNode, seq)
+}
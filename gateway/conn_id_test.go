@@ -0,0 +1,41 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewConnID(t *testing.T) {
+	ids := make([]string, 1000)
+	seen := make(map[string]bool)
+	for i := range ids {
+		ids[i] = newConnID()
+		seen[ids[i]] = true
+	}
+	assertEqual(t, len(seen), len(ids))
+	assertEqual(t, sort.StringsAreSorted(ids), true)
+}
+
+func TestPushToConnID(t *testing.T) {
+	store := newStubWSStore()
+	ws1 := newStubWSConn("1")
+	ws2 := newStubWSConn("2")
+	store.save("match", anonymousMemberID, ws1)
+	store.save("match", anonymousMemberID, ws2)
+	server := NewGatewayServer(store, &FakeAuthServer{})
+
+	body := fmt.Sprintf(`{"app":"match","member_id":-1,"text":"hi","conn_id":"%s"}`, ws2.ID())
+	response := httptest.NewRecorder()
+	server.ServeHTTP(response, httptest.NewRequest(http.MethodPost, pushURLPath, strings.NewReader(body)))
+	assertStatusCode(t, response.Code, http.StatusAccepted)
+	time.Sleep(time.Millisecond * 10)
+
+	assertBufferLengthEqual(t, len(ws1.messages()), 0)
+	assertBufferLengthEqual(t, len(ws2.messages()), 1)
+	assertMessage(t, string(ws2.messages()[0]), string(pushMessageJSONFor("match", anonymousMemberID, "hi")))
+}
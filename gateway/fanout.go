@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+const fanoutShardQueueSize = 1024
+
+// fanoutTask connections of a shard which a push message is delivered to
+type fanoutTask struct {
+	app    string
+	frames *pushFrames
+	conns  []Conn
+	// done called after task is delivered when push message waits for fan-out
+	done func()
+}
+
+// fanout delivers push messages by sharded workers, a connection is always
+// handled by the same worker, so message order of each connection is preserved
+type fanout struct {
+	shards  []chan fanoutTask
+	stats   *slowConsumerStats
+	workers sync.WaitGroup
+}
+
+func defaultFanoutWorkers() int {
+	return runtime.NumCPU()
+}
+
+func newFanout(workers int, stats *slowConsumerStats) *fanout {
+	if workers <= 0 {
+		workers = 1
+	}
+	f := &fanout{
+		shards: make([]chan fanoutTask, workers),
+		stats:  stats,
+	}
+	for i := range f.shards {
+		f.shards[i] = make(chan fanoutTask, fanoutShardQueueSize)
+		f.workers.Add(1)
+		go f.worker(f.shards[i])
+	}
+	return f
+}
+
+func (f *fanout) worker(tasks chan fanoutTask) {
+	defer f.workers.Done()
+	for task := range tasks {
+		for _, conn := range task.conns {
+			frame, err := task.frames.frameFor(conn)
+			if err != nil {
+				continue
+			}
+			f.stats.record(task.app, conn.WriteMessage(frame))
+		}
+		if task.done != nil {
+			task.done()
+		}
+	}
+}
+
+// close stop workers after dispatched tasks are done
+func (f *fanout) close() {
+	for _, shard := range f.shards {
+		close(shard)
+	}
+	f.workers.Wait()
+}
+
+// dispatch split connections across workers
+func (f *fanout) dispatch(pushMsg *PushMessage, conns []Conn) {
+	if len(conns) == 0 {
+		pushMsg.finish()
+		return
+	}
+
+	frames := newPushFrames(pushMsg)
+	if len(f.shards) == 1 {
+		f.shards[0] <- fanoutTask{pushMsg.App, frames, conns, pushMsg.done}
+		return
+	}
+
+	batches := make([][]Conn, len(f.shards))
+	for shard := range batches {
+		batches[shard] = make([]Conn, 0, len(conns)/len(f.shards)+1)
+	}
+	for _, conn := range conns {
+		shard := f.shardFor(conn)
+		batches[shard] = append(batches[shard], conn)
+	}
+	done := batchesDone(pushMsg, batches)
+	for shard, batch := range batches {
+		if len(batch) > 0 {
+			f.shards[shard] <- fanoutTask{pushMsg.App, frames, batch, done}
+		}
+	}
+}
+
+// batchesDone returns func finishing push message after all non-empty
+// batches are delivered, nil when push message does not wait for fan-out
+func batchesDone(pushMsg *PushMessage, batches [][]Conn) func() {
+	if pushMsg.done == nil {
+		return nil
+	}
+	remaining := int32(0)
+	for _, batch := range batches {
+		if len(batch) > 0 {
+			remaining++
+		}
+	}
+	return func() {
+		if atomic.AddInt32(&remaining, -1) == 0 {
+			pushMsg.done()
+		}
+	}
+}
+
+// shardFor FNV-1a hash of connection id, computed inline to avoid
+// allocation for every connection of a broadcast
+func (f *fanout) shardFor(conn Conn) int {
+	key := conn.ID()
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % uint32(len(f.shards)))
+}
@@ -0,0 +1,59 @@
+package gateway
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFanoutPreservesConnectionOrder(t *testing.T) {
+	f := newFanout(4, &slowConsumerStats{})
+	defer f.close()
+
+	var conns []Conn
+	for i := 0; i < 50; i++ {
+		conns = append(conns, newStubWSConn(fmt.Sprintf("127.0.0.1:%d", 10000+i)))
+	}
+
+	messageCount := 100
+	var delivered sync.WaitGroup
+	delivered.Add(messageCount)
+	for i := 0; i < messageCount; i++ {
+		f.dispatch(&PushMessage{App: "match", MemberID: anonymousMemberID, Text: fmt.Sprint(i), done: delivered.Done}, conns)
+	}
+	delivered.Wait()
+
+	for _, conn := range conns {
+		messages := conn.(*StubWSConn).messages()
+		assertBufferLengthEqual(t, len(messages), messageCount)
+		for i, msg := range messages {
+			assertMessage(t, string(msg), string(pushMessageJSONFor("match", anonymousMemberID, fmt.Sprint(i))))
+		}
+	}
+}
+
+func TestFanoutDone(t *testing.T) {
+	f := newFanout(4, &slowConsumerStats{})
+	defer f.close()
+
+	var conns []Conn
+	for i := 0; i < 50; i++ {
+		conns = append(conns, newStubWSConn(fmt.Sprintf("127.0.0.1:%d", 10000+i)))
+	}
+
+	done := make(chan struct{})
+	f.dispatch(&PushMessage{App: "match", MemberID: anonymousMemberID, Text: "hi", done: func() { close(done) }}, conns)
+	select {
+	case <-done:
+	case <-time.After(time.Millisecond * 100):
+		t.Fatal("push message is not done")
+	}
+	for _, conn := range conns {
+		assertBufferLengthEqual(t, len(conn.(*StubWSConn).messages()), 1)
+	}
+
+	done = make(chan struct{})
+	f.dispatch(&PushMessage{App: "match", done: func() { close(done) }}, nil)
+	<-done
+}
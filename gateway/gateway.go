@@ -1,24 +1,44 @@
 package gateway
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 const (
-	missingAuthMessageString        = `{"code":400,"message":"missing auth message"}`
-	unauthorizedMessageString       = `{"code":401,"message":"unauthorized"}`
-	badSubscribeMessageString       = `{"code":400,"message":"bad subscribe message"}`
-	helloStrangerMessageString      = `{"code":200,"message":"hello stranger"}`
-	helloMemberMessageFormat        = `{"code":200,"message":"hello %d"}`
-	subscribeSuccessMessageFormat   = `{"code":200,"message":"subscribe %s success"}`
-	subscribeForbiddenMessageFormat = `{"code":403,"message":"subscribe %s forbidden"}`
+	missingAuthMessageString      = `{"code":400,"message":"missing auth message"}`
+	unauthorizedMessageString     = `{"code":401,"message":"unauthorized"}`
+	authUnavailableMessageString  = `{"code":503,"message":"auth unavailable"}`
+	authExpiredMessageString      = `{"code":401,"message":"auth expired"}`
+	badSubscribeMessageString     = `{"code":400,"message":"bad subscribe message"}`
+	storeUnavailableMessageString = `{"code":503,"message":"store unavailable"}`
+	pongMessageString             = `{"code":200,"message":"pong"}`
+)
+
+// message formats of gateway replies with client provided values, replies
+// are encoded by replyText
+const (
+	helloStrangerMessageText        = "hello stranger"
+	helloMemberMessageFormat        = "hello %d"
+	subscribeSuccessMessageFormat   = "subscribe %s success"
+	subscribeForbiddenMessageFormat = "subscribe %s forbidden"
+	unsubscribeSuccessMessageFormat = "unsubscribe %s success"
+	unsubscribeNotSubscribedFormat  = "unsubscribe %s failed, not subscribed"
+	unsubscribeFailedMessageFormat  = "unsubscribe %s failed"
+	unknownActionMessageFormat      = "unknown action %s"
+	subscribeFailedMessageFormat    = "subscribe %s failed"
+	sinceUnsupportedMessageFormat   = "subscribe %s failed, since is not supported in cluster mode"
 )
 
 const (
@@ -26,20 +46,14 @@ const (
 	pushURLPath      = "/push"
 
 	anonymousMemberID = -1
-)
 
-var (
-	privateApps = []string{"im"}
-)
+	authMessageTimeout = time.Second * 10
+	authTimeout        = time.Second * 10
 
-func isPrivateApp(app string) bool {
-	for _, pa := range privateApps {
-		if app == pa {
-			return true
-		}
-	}
-	return false
-}
+	bearerAuthPrefix      = "Bearer "
+	memberIDHeader        = "X-Member-ID"
+	defaultAuthCookieName = "ws_token"
+)
 
 func isValidMemberID(memberID int) bool {
 	if memberID > 0 {
@@ -48,105 +62,215 @@ func isValidMemberID(memberID int) bool {
 	return false
 }
 
-func missingAuthMessage() string {
-	return wrapGatewayResponseMessage(missingAuthMessageString)
+func helloStrangerText(connID string) string {
+	return helloText(helloStrangerMessageText, connID)
+}
+
+func helloMemberText(memberID int, connID string) string {
+	return helloText(fmt.Sprintf(helloMemberMessageFormat, memberID), connID)
 }
 
-func unauthorizedMessage() string {
-	return wrapGatewayResponseMessage(unauthorizedMessageString)
+func subscribeSuccessText(app string) string {
+	return replyText(http.StatusOK, fmt.Sprintf(subscribeSuccessMessageFormat, app))
 }
 
-func badSubscribeMessage() string {
-	return wrapGatewayResponseMessage(badSubscribeMessageString)
+func subscribeForbiddenText(app string) string {
+	return replyText(http.StatusForbidden, fmt.Sprintf(subscribeForbiddenMessageFormat, app))
 }
 
-func helloStrangerMessage() string {
-	return wrapGatewayResponseMessage(helloStrangerMessageString)
+func unsubscribeSuccessText(app string) string {
+	return replyText(http.StatusOK, fmt.Sprintf(unsubscribeSuccessMessageFormat, app))
 }
 
-func helloMessageForMember(memberID int) string {
-	return wrapGatewayResponseMessage(fmt.Sprintf(helloMemberMessageFormat, memberID))
+func unsubscribeNotSubscribedText(app string) string {
+	return replyText(http.StatusNotFound, fmt.Sprintf(unsubscribeNotSubscribedFormat, app))
 }
 
-func subscribeSuccessMessageForApp(app string) string {
-	return wrapGatewayResponseMessage(fmt.Sprintf(subscribeSuccessMessageFormat, app))
+func unsubscribeFailedText(app string) string {
+	return replyText(http.StatusServiceUnavailable, fmt.Sprintf(unsubscribeFailedMessageFormat, app))
 }
 
-func subscribeForbiddenMessageForApp(app string) string {
-	return wrapGatewayResponseMessage(fmt.Sprintf(subscribeForbiddenMessageFormat, app))
+func subscribeFailedText(app string) string {
+	return replyText(http.StatusServiceUnavailable, fmt.Sprintf(subscribeFailedMessageFormat, app))
+}
+
+func sinceUnsupportedText(app string) string {
+	return replyText(http.StatusBadRequest, fmt.Sprintf(sinceUnsupportedMessageFormat, app))
+}
+
+func unknownActionText(action string) string {
+	return replyText(http.StatusBadRequest, fmt.Sprintf(unknownActionMessageFormat, action))
 }
 
 func wrapGatewayResponseMessage(message string) string {
-	pushMsg := new(PushMessage)
-	pushMsg.App = "gateway"
-	pushMsg.MemberID = -1
-	pushMsg.Text = message
-	m, _ := json.Marshal(pushMsg)
-	return string(m)
+	return wrapGatewayReply("", message)
+}
+
+// wrapGatewayReply wrap gateway response message as reply of client request id
+func wrapGatewayReply(id string, message string) string {
+	return string(mustEncodePushMessage(gatewayReply(id, message), false))
 }
 
-// Conn websocket connection interface
+func gatewayReply(id string, message string) *PushMessage {
+	return &PushMessage{
+		ID:       id,
+		App:      "gateway",
+		MemberID: -1,
+		Data:     json.RawMessage(message),
+	}
+}
+
+// Conn websocket connection interface, ID is unique id of connection
 type Conn interface {
+	ID() string
 	ReadMessage() (msg []byte, err error)
 	WriteMessage(msg []byte) (err error)
 	RemoteAddr() string
 }
 
-type wsStore interface {
-	save(app string, memberID int, ws Conn) error
-	delete(memberID int, ws Conn)
-	publicWSClientsForApp(app string) []Conn
-	privateWSClientsForMember(memberID int) []Conn
-	apps() []string
-}
-
 // AuthMessage client auth message
 type AuthMessage struct {
 	MemberID int    `json:"member_id"`
 	Token    string `json:"token"`
 }
 
-// SubscribeMessage client subscribe data message
+// SubscribeMessage client subscribe data message, Since or Last asks for
+// messages of app history after sequence number Since or last Last messages
 type SubscribeMessage struct {
-	App string `json:"app"`
+	App   string  `json:"app"`
+	Since *uint64 `json:"since,omitempty"`
+	Last  int     `json:"last,omitempty"`
 }
 
-// PushMessage push request message
+// PushMessage push request message, ID is client request id of gateway reply,
+// payload is Text or raw JSON Data, message is only pushed to connection
+// ConnID when it is set
 type PushMessage struct {
-	App      string `json:"app"`
-	MemberID int    `json:"member_id"`
-	Text     string `json:"text"`
+	ID       string          `json:"id,omitempty"`
+	App      string          `json:"app"`
+	MemberID int             `json:"member_id"`
+	Text     string          `json:"text"`
+	Data     json.RawMessage `json:"data,omitempty"`
+	ConnID   string          `json:"conn_id,omitempty"`
+	// Seq sequence number of message in app history, assigned by gateway node
+	Seq uint64 `json:"seq,omitempty"`
+
+	// done called when message is fanned out to local connections
+	done func()
+}
+
+// finish report message is fanned out
+func (m *PushMessage) finish() {
+	if m.done != nil {
+		m.done()
+	}
 }
 
-// AuthServer client auth server interface
+// AuthServer client auth server interface,
+// AuthServer can implement Authenticator to report auth backend errors
 type AuthServer interface {
 	Auth(int, string) bool
 }
 
-// Server websocket gateway server
+func isAppAllowed(allowedApps []string, app string) bool {
+	if allowedApps == nil {
+		return true
+	}
+	return containsString(allowedApps, app)
+}
+
+// Server websocket gateway server, Server serves both websocket and push
+// requests, use WebSocketHandler and PushHandler to serve them on separate listeners
 type Server struct {
 	http.Handler
 
+	wsHandler   http.Handler
+	pushHandler http.Handler
+
 	upgrader      websocket.Upgrader
-	wsClientStore wsStore
-	authServer    AuthServer
-	pushChan      chan *PushMessage
+	wsClientStore Store
+	authenticator Authenticator
+	pushQueue     *pushQueue
+
+	apps               *AppRegistry
+	authCookieName     string
+	allowedOrigins     map[string]bool
+	closeOnAuthExpired bool
+	pushAuth           *PushAuth
+	heartbeat          heartbeatConfig
+	sendQueueSize      int
+	writeWait          time.Duration
+	slowConsumerPolicy SlowConsumerPolicy
+	fanoutWorkers      int
+	fanout             *fanout
+	pushQueueConfig    PushQueueConfig
+	drainWindow        time.Duration
+	drainBatchSize     int
+	bus                MessageBus
+	unsubscribeBus     context.CancelFunc
+	node               string
+	presenceRegistry   PresenceRegistry
+	presenceTTL        time.Duration
+	presence           *presenceStore
+
+	shutdownMu   sync.RWMutex
+	shuttingDown bool
+	conns        sync.Map
+	pushLoopDone chan struct{}
+	history      messageHistory
+
+	heartbeatTimeouts int64
+	slowConsumers     slowConsumerStats
 }
 
 // NewGatewayServer create a new gateway server
-func NewGatewayServer(store wsStore, authServer AuthServer) *Server {
+func NewGatewayServer(store Store, authServer AuthServer, opts ...ServerOption) *Server {
 	server := &Server{
 		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true
-			},
+			Subprotocols: []string{rawJSONSubprotocol},
 		},
 		wsClientStore: store,
-		authServer:    authServer,
-		pushChan:      make(chan *PushMessage, 1000),
+		authenticator: authenticatorFor(authServer),
+		apps:          DefaultAppRegistry(),
+
+		authCookieName: defaultAuthCookieName,
+		heartbeat:      defaultHeartbeatConfig(),
+		sendQueueSize:  defaultSendQueueSize,
+		writeWait:      defaultWriteWait,
+
+		slowConsumerPolicy: DropOldest,
+		fanoutWorkers:      defaultFanoutWorkers(),
+		pushQueueConfig:    defaultPushQueueConfig(),
+		drainWindow:        defaultDrainWindow,
+		drainBatchSize:     defaultDrainBatchSize,
+		pushLoopDone:       make(chan struct{}),
 	}
 
+	for _, opt := range opts {
+		opt(server)
+	}
+	server.upgrader.CheckOrigin = server.checkOrigin
+	server.history.apps = server.apps
+
+	server.fanout = newFanout(server.fanoutWorkers, &server.slowConsumers)
+	server.pushQueue = newPushQueue(server.pushQueueConfig)
 	go server.pushLoop()
+	if server.presenceRegistry != nil {
+		server.presence = newPresenceStore(server.wsClientStore, server.apps, server.presenceRegistry, server.node, server.presenceTTL)
+		server.wsClientStore = server.presence
+	}
+	if server.bus == nil {
+		server.bus = NewInProcessBus()
+	}
+	server.subscribeBus()
+
+	wsRouter := http.NewServeMux()
+	wsRouter.HandleFunc(websocketURLPath, server.websocket)
+	server.wsHandler = wsRouter
+
+	pushRouter := http.NewServeMux()
+	pushRouter.HandleFunc(pushURLPath, server.push)
+	server.pushHandler = pushRouter
 
 	router := http.NewServeMux()
 	router.HandleFunc(websocketURLPath, server.websocket)
@@ -156,8 +280,35 @@ func NewGatewayServer(store wsStore, authServer AuthServer) *Server {
 	return server
 }
 
+// WebSocketHandler returns handler of public websocket connections
+func (g *Server) WebSocketHandler() http.Handler {
+	return g.wsHandler
+}
+
+// PushHandler returns handler of internal push api
+func (g *Server) PushHandler() http.Handler {
+	return g.pushHandler
+}
+
 func (g *Server) push(w http.ResponseWriter, r *http.Request) {
-	pushMsg, err := g.bindPushMessage(r)
+	postData, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		errMessage := fmt.Sprintf("bind push message error %v\n", fmt.Errorf("failed to read request body"))
+		log.Println(errMessage)
+		fmt.Fprint(w, errMessage)
+		return
+	}
+
+	var pushKey PushKey
+	if g.pushAuth != nil {
+		if pushKey, err = g.pushAuth.verify(r, postData); err != nil {
+			g.rejectPush(w, err)
+			return
+		}
+	}
+
+	pushMsg, err := g.bindPushMessage(postData)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		errMessage := fmt.Sprintf("bind push message error %v\n", err)
@@ -166,133 +317,477 @@ func (g *Server) push(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.WriteHeader(http.StatusAccepted)
+	if g.pushAuth != nil {
+		if err := g.pushAuth.authorize(pushKey, pushMsg.App); err != nil {
+			g.rejectPush(w, err)
+			return
+		}
+	}
 
-	g.pushChan <- pushMsg
+	g.publishPush(w, r, pushMsg)
 }
 
 func (g *Server) pushLoop() {
-	for msg := range g.pushChan {
-		if isPrivateApp(msg.App) {
-			g.imMessage(msg)
-			continue
-		}
-		g.publicMessage(msg)
+	defer close(g.pushLoopDone)
+	for msg := range g.pushQueue.messages {
+		g.dispatchPush(msg)
 	}
+	g.fanout.close()
 }
 
-func (g *Server) bindPushMessage(r *http.Request) (*PushMessage, error) {
-	var pushMsg PushMessage
-	postData, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read request body")
+func (g *Server) rejectPush(w http.ResponseWriter, err error) {
+	status := http.StatusUnauthorized
+	if authErr, ok := err.(*pushAuthError); ok {
+		status = authErr.status
 	}
+	w.WriteHeader(status)
+	errMessage := fmt.Sprintf("push request rejected: %v\n", err)
+	log.Println(errMessage)
+	fmt.Fprint(w, errMessage)
+}
 
+func (g *Server) bindPushMessage(postData []byte) (*PushMessage, error) {
+	var pushMsg PushMessage
 	if err := json.Unmarshal(postData, &pushMsg); err != nil {
 		return nil, fmt.Errorf("failed to parse request body")
 	}
 	return &pushMsg, nil
 }
 
+// dispatchPush fan out push message, message of app keeping history is
+// recorded and fanned out while history is locked
+func (g *Server) dispatchPush(pushMsg *PushMessage) {
+	if history := g.history.forApp(pushMsg.App); history != nil && pushMsg.ConnID == "" {
+		history.mu.Lock()
+		defer history.mu.Unlock()
+		history.recordLocked(pushMsg)
+	}
+
+	if g.apps.isPrivate(pushMsg.App) {
+		g.privateMessage(pushMsg)
+		return
+	}
+	g.publicMessage(pushMsg)
+}
+
 func (g *Server) publicMessage(pushMsg *PushMessage) {
-	msg, _ := json.Marshal(pushMsg)
-	conns := g.wsClientStore.publicWSClientsForApp(pushMsg.App)
-	for _, conn := range conns {
-		conn.WriteMessage(msg)
+	conns, err := g.wsClientStore.PublicConns(context.Background(), pushMsg.App)
+	if err != nil {
+		log.Printf("load connections of app %s failed: %v", pushMsg.App, err)
+		pushMsg.finish()
+		return
+	}
+	g.fanout.dispatch(pushMsg, targetConns(conns, pushMsg.ConnID))
+}
+
+func (g *Server) privateMessage(pushMsg *PushMessage) {
+	conns, err := g.wsClientStore.PrivateConns(context.Background(), pushMsg.App, pushMsg.MemberID)
+	if err != nil {
+		log.Printf("load connections of member %d for app %s failed: %v", pushMsg.MemberID, pushMsg.App, err)
+		pushMsg.finish()
+		return
 	}
+	g.fanout.dispatch(pushMsg, targetConns(conns, pushMsg.ConnID))
 }
 
-func (g *Server) imMessage(pushMsg *PushMessage) {
-	msg, _ := json.Marshal(pushMsg)
-	conns := g.wsClientStore.privateWSClientsForMember(pushMsg.MemberID)
+// targetConns returns connection connID of conns, all conns when connID is empty
+func targetConns(conns []Conn, connID string) []Conn {
+	if connID == "" {
+		return conns
+	}
 	for _, conn := range conns {
-		conn.WriteMessage(msg)
+		if conn.ID() == connID {
+			return []Conn{conn}
+		}
 	}
+	return nil
 }
 
 func (g *Server) websocket(w http.ResponseWriter, r *http.Request) {
-	ws, err := g.upgrader.Upgrade(w, r, nil)
+	if g.isShuttingDown() {
+		g.rejectShuttingDown(w)
+		return
+	}
+
+	// reject foreign origins before credentials reach auth server
+	if !g.checkOrigin(r) {
+		log.Printf("websocket upgrade from origin %q forbidden", r.Header.Get("Origin"))
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	authMsg, hasCredentials := g.upgradeAuthMessage(r)
+	var identity Identity
+	if hasCredentials {
+		var err error
+		identity, err = g.authUpgradeRequest(r.Context(), authMsg)
+		if err != nil {
+			errMessage := fmt.Sprintf("auth member %d during upgrade failed: %v", authMsg.MemberID, err)
+			log.Println(errMessage)
+			if err == ErrUnauthorized {
+				http.Error(w, unauthorizedMessageString, http.StatusUnauthorized)
+			} else {
+				http.Error(w, authUnavailableMessageString, http.StatusServiceUnavailable)
+			}
+			return
+		}
+	}
+
+	conn, err := g.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		errMessage := fmt.Sprintf("websocket upgrade failed: %v", err)
 		log.Println(errMessage)
 		return
 	}
+	ws := newWSConn(conn, wsConnConfig{
+		heartbeat:          g.heartbeat,
+		sendQueueSize:      g.sendQueueSize,
+		writeWait:          g.writeWait,
+		slowConsumerPolicy: g.slowConsumerPolicy,
+	})
+	ws.rawJSONMode = isRawJSONMode(r, conn)
 	defer ws.Close()
 
-	authMsg, err := g.getAuthMessage(ws)
+	if !g.acceptConn(ws) {
+		ws.closeWithCode(websocket.CloseGoingAway, goingAwayReason)
+		return
+	}
+	defer g.releaseConn(ws)
+
+	if hasCredentials {
+		ws.reply("", helloMemberText(identity.MemberID, ws.ID()))
+		g.waitForSubscribe(r.Context(), ws, identity)
+		return
+	}
+
+	authMsg, id, err := g.getAuthMessage(ws)
 	if err != nil {
 		errMessage := fmt.Sprintf("get auth message failed: %v", err)
 		log.Println(errMessage)
-		ws.WriteMessage(websocket.TextMessage, []byte(missingAuthMessage()))
+		ws.reply(id, missingAuthMessageString)
 		return
 	}
 
-	memberID := g.authMember(ws, authMsg)
-	g.waitForSubscribe(ws, memberID)
+	identity, err = g.authMember(r.Context(), ws, id, authMsg)
+	if err != nil {
+		errMessage := fmt.Sprintf("auth member %d failed: %v", authMsg.MemberID, err)
+		log.Println(errMessage)
+		return
+	}
+	g.waitForSubscribe(r.Context(), ws, identity)
+}
+
+// upgradeAuthMessage get credentials from upgrade request, token is read from
+// Authorization header, auth cookie or token query parameter, member id is read
+// from member_id query parameter or X-Member-ID header
+func (g *Server) upgradeAuthMessage(r *http.Request) (authMsg AuthMessage, ok bool) {
+	query := r.URL.Query()
+
+	token := ""
+	if authorization := r.Header.Get("Authorization"); strings.HasPrefix(authorization, bearerAuthPrefix) {
+		token = strings.TrimPrefix(authorization, bearerAuthPrefix)
+	} else if cookieToken, ok := g.authCookieToken(r); ok {
+		token = cookieToken
+	} else {
+		token = query.Get("token")
+	}
+
+	if token == "" {
+		return AuthMessage{}, false
+	}
+
+	memberID := query.Get("member_id")
+	if memberID == "" {
+		memberID = r.Header.Get(memberIDHeader)
+	}
+
+	authMsg.Token = token
+	authMsg.MemberID, _ = strconv.Atoi(memberID)
+	return authMsg, true
 }
 
-func (g *Server) getAuthMessage(ws *websocket.Conn) (authMsg AuthMessage, err error) {
-	msg, err := g.readMessageWithTimeout(ws, time.Second*10)
-	err = json.Unmarshal(msg, &authMsg)
-	if err != nil {
-		authMsg = AuthMessage{}
+func (g *Server) authUpgradeRequest(ctx context.Context, auth AuthMessage) (Identity, error) {
+	if !isValidMemberID(auth.MemberID) {
+		return Identity{}, ErrUnauthorized
 	}
-	if authMsg.MemberID == 0 {
-		err = fmt.Errorf("member id is 0")
+
+	ctx, cancel := context.WithTimeout(ctx, authTimeout)
+	defer cancel()
+
+	identity, err := g.authenticator.Authenticate(ctx, auth)
+	if err != nil && err != ErrUnauthorized {
+		err = ErrAuthUnavailable
 	}
-	return
+	return identity, err
 }
 
-func (g *Server) readMessageWithTimeout(ws *websocket.Conn, timeout time.Duration) ([]byte, error) {
-	ws.SetReadDeadline(time.Now().Add(timeout))
-	_, msg, err := ws.ReadMessage()
-	return msg, err
+// getAuthMessage read first client message which must be a v0 AuthMessage
+// or a v1 auth Envelope, returns request id of the message
+func (g *Server) getAuthMessage(ws *wsConn) (authMsg AuthMessage, id string, err error) {
+	msg, err := ws.readMessageWithTimeout(authMessageTimeout)
+	if err != nil {
+		return AuthMessage{}, "", err
+	}
+
+	clientMsg, err := parseClientMessage(msg)
+	if err != nil {
+		if protocolErr, ok := err.(*protocolError); ok {
+			id = protocolErr.id
+		}
+		return AuthMessage{}, id, err
+	}
+	if clientMsg.action != authAction || clientMsg.auth.MemberID == 0 {
+		return AuthMessage{}, clientMsg.id, fmt.Errorf("member id is 0")
+	}
+	return clientMsg.auth, clientMsg.id, nil
 }
 
-func (g *Server) authMember(ws *websocket.Conn, auth AuthMessage) (memberID int) {
+// authMember authenticate member and reply auth result
+func (g *Server) authMember(ctx context.Context, ws *wsConn, id string, auth AuthMessage) (Identity, error) {
 	if !isValidMemberID(auth.MemberID) {
-		ws.WriteMessage(websocket.TextMessage, []byte(helloStrangerMessage()))
-		return anonymousMemberID
+		ws.reply(id, helloStrangerText(ws.ID()))
+		return anonymousIdentity, nil
 	}
 
-	if !g.authServer.Auth(auth.MemberID, auth.Token) {
-		ws.WriteMessage(websocket.TextMessage, []byte(unauthorizedMessage()))
-		return anonymousMemberID
+	identity, err := g.authenticate(ctx, ws, auth)
+	switch err {
+	case nil:
+		ws.reply(id, helloMemberText(identity.MemberID, ws.ID()))
+		return identity, nil
+	case ErrUnauthorized:
+		ws.reply(id, unauthorizedMessageString)
+		return anonymousIdentity, nil
 	}
 
-	ws.WriteMessage(websocket.TextMessage, []byte(helloMessageForMember(auth.MemberID)))
-	return auth.MemberID
+	ws.reply(id, authUnavailableMessageString)
+	return anonymousIdentity, err
 }
 
-func (g *Server) clearWSReadDeadline(ws *websocket.Conn) {
-	ws.SetReadDeadline(time.Time{})
+// authenticate member, auth is canceled when client disconnected
+func (g *Server) authenticate(ctx context.Context, ws *wsConn, auth AuthMessage) (Identity, error) {
+	ctx, cancel := context.WithTimeout(ctx, authTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-ws.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	identity, err := g.authenticator.Authenticate(ctx, auth)
+	if err != nil && err != ErrUnauthorized {
+		err = ErrAuthUnavailable
+	}
+	return identity, err
 }
 
-func (g *Server) waitForSubscribe(ws *websocket.Conn, memberID int) {
+func (g *Server) waitForSubscribe(ctx context.Context, ws *wsConn, identity Identity) {
+	s := newSession(ws, identity, g.apps)
+	s.mu.Lock()
+	g.scheduleAuthExpiry(s)
+	s.mu.Unlock()
+
 	for {
-		g.clearWSReadDeadline(ws)
-		_, msg, err := ws.ReadMessage()
+		msg, err := ws.ReadMessage()
 		if err != nil {
 			errMessage := fmt.Sprintf("read message failed: %v", err)
 			log.Println(errMessage)
-			g.wsClientStore.delete(memberID, newWSConn(ws))
+			if isHeartbeatTimeout(err) {
+				atomic.AddInt64(&g.heartbeatTimeouts, 1)
+			}
+			if err := g.wsClientStore.Delete(ctx, s.close(), ws); err != nil {
+				log.Printf("delete connection %s failed: %v", ws.ID(), err)
+			}
 			return
 		}
 
-		var sub SubscribeMessage
-		if err := json.Unmarshal(msg, &sub); err != nil || sub.App == "" {
-			ws.WriteMessage(websocket.TextMessage, []byte(badSubscribeMessage()))
+		clientMsg, err := parseClientMessage(msg)
+		if err != nil {
+			protocolErr := err.(*protocolError)
+			ws.reply(protocolErr.id, protocolErr.text)
 			continue
 		}
+		g.handleClientMessage(ctx, s, clientMsg)
+	}
+}
 
-		if !isValidMemberID(memberID) && isPrivateApp(sub.App) {
-			ws.WriteMessage(websocket.TextMessage, []byte(subscribeForbiddenMessageForApp(sub.App)))
-			continue
+func (g *Server) handleClientMessage(ctx context.Context, s *session, clientMsg clientMessage) {
+	switch clientMsg.action {
+	case authAction:
+		g.reauthMember(ctx, s, clientMsg.id, clientMsg.auth)
+	case subscribeAction:
+		g.subscribe(ctx, s, clientMsg.id, clientMsg.app, clientMsg.replay)
+	case unsubscribeAction:
+		g.unsubscribe(ctx, s, clientMsg.id, clientMsg.app)
+	case pingAction:
+		s.ws.reply(clientMsg.id, pongMessageString)
+	case listAction:
+		g.listSubscriptions(ctx, s, clientMsg.id)
+	default:
+		s.ws.reply(clientMsg.id, unknownActionText(clientMsg.action))
+	}
+}
+
+func (g *Server) subscribe(ctx context.Context, s *session, id string, app string, replay replayRequest) {
+	if app == "" {
+		s.ws.reply(id, badSubscribeMessageString)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.canSubscribe(app) {
+		s.ws.reply(id, subscribeForbiddenText(app))
+		return
+	}
+
+	// history is locked while connection is saved and history is replayed,
+	// so each message is either replayed or delivered live, after replay
+	history := g.history.forApp(app)
+	// seq is assigned by each node, seq of other nodes means nothing here
+	if history != nil && replay.since != nil && g.sharedBus() {
+		s.ws.reply(id, sinceUnsupportedText(app))
+		return
+	}
+	if history != nil && replay.requested() {
+		history.mu.Lock()
+		defer history.mu.Unlock()
+	}
+
+	if err := g.wsClientStore.Save(ctx, app, s.identity.MemberID, s.ws); err != nil {
+		log.Printf("save connection %s for app %s failed: %v", s.ws.ID(), app, err)
+		s.ws.reply(id, subscribeFailedText(app))
+		return
+	}
+	s.ws.reply(id, subscribeSuccessText(app))
+	s.apps[app] = true
+
+	if history != nil && replay.requested() {
+		// replay is capped by send queue space, so replay alone does not trigger
+		// slow consumer policy, clients find skipped messages by gaps of seq
+		messages := history.replayLocked(replay, s.identity.MemberID, g.apps.isPrivate(app))
+		if space := s.ws.sendQueueSpace(); len(messages) > space {
+			messages = messages[len(messages)-space:]
+		}
+		for _, pushMsg := range messages {
+			frame, err := encodePushMessage(pushMsg, s.ws.rawJSON())
+			if err != nil {
+				log.Printf("encode history message %d of app %s failed: %v", pushMsg.Seq, app, err)
+				continue
+			}
+			s.ws.WriteMessage(frame)
+		}
+	}
+}
+
+func (g *Server) unsubscribe(ctx context.Context, s *session, id string, app string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.apps[app] {
+		s.ws.reply(id, unsubscribeNotSubscribedText(app))
+		return
+	}
+
+	if err := g.wsClientStore.DeleteForApp(ctx, app, s.identity.MemberID, s.ws); err != nil {
+		log.Printf("delete connection %s for app %s failed: %v", s.ws.ID(), app, err)
+		s.ws.reply(id, unsubscribeFailedText(app))
+		return
+	}
+	delete(s.apps, app)
+	s.ws.reply(id, unsubscribeSuccessText(app))
+}
+
+func (g *Server) listSubscriptions(ctx context.Context, s *session, id string) {
+	apps, err := g.wsClientStore.Subscriptions(ctx, s.ws.ID())
+	if err != nil {
+		log.Printf("load subscriptions of connection %s failed: %v", s.ws.ID(), err)
+		s.ws.reply(id, storeUnavailableMessageString)
+		return
+	}
+	s.ws.reply(id, subscriptionsText(apps))
+}
+
+// reauthMember refresh identity of session, private subscriptions are dropped
+// when credentials are rejected or member changed
+func (g *Server) reauthMember(ctx context.Context, s *session, id string, auth AuthMessage) {
+	if !isValidMemberID(auth.MemberID) {
+		s.ws.reply(id, helloStrangerText(s.ws.ID()))
+		g.setIdentity(ctx, s, anonymousIdentity)
+		return
+	}
+
+	identity, err := g.authenticate(ctx, s.ws, auth)
+	switch err {
+	case nil:
+		s.ws.reply(id, helloMemberText(identity.MemberID, s.ws.ID()))
+		g.setIdentity(ctx, s, identity)
+	case ErrUnauthorized:
+		s.ws.reply(id, unauthorizedMessageString)
+		g.setIdentity(ctx, s, anonymousIdentity)
+	default:
+		log.Printf("reauth member %d failed: %v", auth.MemberID, err)
+		s.ws.reply(id, authUnavailableMessageString)
+	}
+}
+
+func (g *Server) setIdentity(ctx context.Context, s *session, identity Identity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g.setIdentityLocked(ctx, s, identity)
+}
+
+// setIdentityLocked replace identity of session and drop subscriptions
+// the new identity can not keep, must hold s.mu
+func (g *Server) setIdentityLocked(ctx context.Context, s *session, identity Identity) {
+	oldMemberID := s.identity.MemberID
+	s.identity = identity
+
+	for app := range s.apps {
+		if !s.canSubscribe(app) || g.apps.isPrivate(app) && oldMemberID != identity.MemberID {
+			delete(s.apps, app)
+			if err := g.wsClientStore.DeleteForApp(ctx, app, oldMemberID, s.ws); err != nil {
+				log.Printf("delete connection %s for app %s failed: %v", s.ws.ID(), app, err)
+			}
 		}
+	}
+
+	g.scheduleAuthExpiry(s)
+}
+
+// scheduleAuthExpiry start expiry timer for session identity, must hold s.mu
+func (g *Server) scheduleAuthExpiry(s *session) {
+	if s.expiryTimer != nil {
+		s.expiryTimer.Stop()
+		s.expiryTimer = nil
+	}
+
+	expiresAt := s.identity.ExpiresAt
+	if expiresAt.IsZero() {
+		return
+	}
+
+	s.expiryTimer = time.AfterFunc(time.Until(expiresAt), func() {
+		g.authExpired(s, expiresAt)
+	})
+}
+
+func (g *Server) authExpired(s *session, expiresAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-		ws.WriteMessage(websocket.TextMessage, []byte(subscribeSuccessMessageForApp(sub.App)))
-		g.wsClientStore.save(sub.App, memberID, newWSConn(ws))
+	if s.closed || !s.identity.ExpiresAt.Equal(expiresAt) {
+		return
+	}
+
+	s.ws.reply("", authExpiredMessageString)
+	if g.closeOnAuthExpired {
+		s.ws.Close()
+		return
 	}
+	g.setIdentityLocked(context.Background(), s, anonymousIdentity)
 }
 
 type wsCount struct {
@@ -300,24 +795,46 @@ type wsCount struct {
 	count int
 }
 
-type statStore interface {
-	appsWSClientCount() []wsCount
+// statCounter source of gateway counters shown by StatServer
+type statCounter interface {
+	counters() []wsCount
 }
 
 // StatServer store gateway stat
 type StatServer struct {
-	store statStore
+	store    StatStore
+	counters []statCounter
 }
 
-// NewStatServer create a new statServer
-func NewStatServer(store statStore) *StatServer {
+// NewStatServer create a new statServer, counters are shown after ws client count
+func NewStatServer(store StatStore, counters ...statCounter) *StatServer {
 	return &StatServer{
-		store: store,
+		store:    store,
+		counters: counters,
 	}
 }
 
 func (s *StatServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	for _, wc := range s.store.appsWSClientCount() {
-		fmt.Fprintf(w, "%s %d\n", wc.name, wc.count)
+	appCounts, err := s.store.AppsConnCount(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	for _, ac := range appCounts {
+		fmt.Fprintf(w, "%s %d\n", ac.App, ac.Count)
+	}
+	for _, counter := range s.counters {
+		for _, c := range counter.counters() {
+			fmt.Fprintf(w, "%s %d\n", c.name, c.count)
+		}
+	}
+}
+
+// counters returns gateway counters
+func (g *Server) counters() []wsCount {
+	result := []wsCount{
+		{"heartbeat_timeouts", int(atomic.LoadInt64(&g.heartbeatTimeouts))},
 	}
+	result = append(result, g.pushQueue.counters()...)
+	return append(result, g.slowConsumers.counters()...)
 }
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -39,7 +40,7 @@ func TestWithAuthentication(t *testing.T) {
 			assertStatusCode(t, response.StatusCode, http.StatusSwitchingProtocols)
 			mustSendAuthMessage(t, ws, tt.memberID, tt.token)
 			msg := mustReadMessageWithTimeout(t, ws, time.Millisecond*10)
-			assertMessage(t, msg, tt.wantedAuthReply)
+			assertMessage(t, stripConnID(msg), stripConnID(tt.wantedAuthReply))
 
 			assertSubscribe(t, ws, tt.subscribedApps, tt.valid)
 
@@ -47,7 +48,7 @@ func TestWithAuthentication(t *testing.T) {
 			if tt.valid {
 				wantImClientCount = 1
 			}
-			assertWSClientCount(t, len(store.privateWSClientsForMember(tt.memberID)), wantImClientCount)
+			assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, tt.memberID)), wantImClientCount)
 			assertWSClientCount(t, len(store.publicWSClientsForApp("match")), 1)
 		})
 	}
@@ -74,9 +75,7 @@ func TestWithNoAuthentication(t *testing.T) {
 func TestPushMessage(t *testing.T) {
 	imMemberID := 123456
 	authServer := &FakeAuthServer{}
-	store := &StubWSStore{
-		imClient: make(map[int][]Conn),
-	}
+	store := newStubWSStore()
 	ws1 := newStubWSConn("1")
 	ws2 := newStubWSConn("2")
 	store.save("match", anonymousMemberID, ws1)
@@ -91,14 +90,16 @@ func TestPushMessage(t *testing.T) {
 		response := httptest.NewRecorder()
 		server.ServeHTTP(response, request)
 		time.Sleep(time.Millisecond * 10)
-		assertEqual(t, store.publicWSClientsForAppWasCalled, true)
+		publicWasCalled, _ := store.wasCalled()
+		assertEqual(t, publicWasCalled, true)
 		assertStatusCode(t, response.Code, http.StatusAccepted)
 
-		assertBufferLengthEqual(t, len(ws1.buffer), 1)
-		assertMessage(t, string(ws1.buffer[0]), string(pushMessageJSONFor("match", anonymousMemberID, msgText)))
+		ws1Messages, ws2Messages := ws1.messages(), ws2.messages()
+		assertBufferLengthEqual(t, len(ws1Messages), 1)
+		assertMessage(t, string(ws1Messages[0]), string(pushMessageJSONFor("match", anonymousMemberID, msgText)))
 
-		assertBufferLengthEqual(t, len(ws2.buffer), 1)
-		assertMessage(t, string(ws2.buffer[0]), string(pushMessageJSONFor("match", anonymousMemberID, msgText)))
+		assertBufferLengthEqual(t, len(ws2Messages), 1)
+		assertMessage(t, string(ws2Messages[0]), string(pushMessageJSONFor("match", anonymousMemberID, msgText)))
 	})
 
 	t.Run("push im message", func(t *testing.T) {
@@ -110,11 +111,13 @@ func TestPushMessage(t *testing.T) {
 		server.ServeHTTP(response, request)
 		time.Sleep(time.Millisecond * 10)
 		assertStatusCode(t, response.Code, http.StatusAccepted)
-		assertEqual(t, store.privateWSClientsForMemberWasCalled, true)
+		_, privateWasCalled := store.wasCalled()
+		assertEqual(t, privateWasCalled, true)
 
-		assertBufferLengthEqual(t, len(ws1.buffer), 0)
-		assertBufferLengthEqual(t, len(ws2.buffer), 1)
-		assertMessage(t, string(ws2.buffer[0]), string(pushMessageJSONFor(imApp, imMemberID, msgText)))
+		ws1Messages, ws2Messages := ws1.messages(), ws2.messages()
+		assertBufferLengthEqual(t, len(ws1Messages), 0)
+		assertBufferLengthEqual(t, len(ws2Messages), 1)
+		assertMessage(t, string(ws2Messages[0]), string(pushMessageJSONFor(imApp, imMemberID, msgText)))
 	})
 
 	t.Run("push request given not valid json", func(t *testing.T) {
@@ -143,17 +146,17 @@ func TestWSClose(t *testing.T) {
 
 	time.Sleep(time.Millisecond * 10)
 	assertWSClientCount(t, len(store.publicWSClientsForApp("match")), 2)
-	assertWSClientCount(t, len(store.privateWSClientsForMember(123456)), 1)
+	assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, 123456)), 1)
 
 	ws1.Close()
 	time.Sleep(time.Millisecond * 10)
 	assertWSClientCount(t, len(store.publicWSClientsForApp("match")), 1)
-	assertWSClientCount(t, len(store.privateWSClientsForMember(123456)), 1)
+	assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, 123456)), 1)
 
 	ws2.Close()
 	time.Sleep(time.Millisecond * 10)
 	assertWSClientCount(t, len(store.publicWSClientsForApp("match")), 0)
-	assertWSClientCount(t, len(store.privateWSClientsForMember(123456)), 0)
+	assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, 123456)), 0)
 }
 
 func TestWSPingPong(t *testing.T) {
@@ -179,9 +182,7 @@ func TestWSPingPong(t *testing.T) {
 
 func TestWSClientCount(t *testing.T) {
 	imMemberID := 123456
-	store := &StubWSStore{
-		imClient: make(map[int][]Conn),
-	}
+	store := newStubWSStore()
 	ws1 := newStubWSConn("1")
 	ws2 := newStubWSConn("2")
 	store.save("match", anonymousMemberID, ws1)
@@ -199,7 +200,7 @@ func TestWSClientCount(t *testing.T) {
 }
 
 func newServer() (*httptest.Server, *StubWSStore) {
-	store := &StubWSStore{imClient: make(map[int][]Conn)}
+	store := newStubWSStore()
 	authServer := &FakeAuthServer{}
 	server := httptest.NewServer(NewGatewayServer(store, authServer))
 	return server, store
@@ -236,6 +237,22 @@ func assertMessage(t *testing.T, got, want string) {
 	}
 }
 
+var connIDPattern = regexp.MustCompile(`,\\?"conn_id\\?":\\?"[0-9a-f-]*\\?"`)
+
+// stripConnID remove connection id from hello message
+func stripConnID(msg string) string {
+	return connIDPattern.ReplaceAllString(msg, "")
+}
+
+// assertHelloMessage assert hello message ignoring value of connection id
+func assertHelloMessage(t *testing.T, got, want string) {
+	t.Helper()
+	if !connIDPattern.MatchString(got) {
+		t.Errorf("got hello message %s without connection id", got)
+	}
+	assertMessage(t, stripConnID(got), stripConnID(want))
+}
+
 func assertResponse(t *testing.T, got, want string) {
 	t.Helper()
 	if got != want {
@@ -307,7 +324,7 @@ func assertSubscribe(t *testing.T, ws *websocket.Conn, apps []string, isValid bo
 			want = subscribeSuccessMessageForApp(app)
 		}
 
-		if !isValid && isPrivateApp(app) {
+		if !isValid && DefaultAppRegistry().isPrivate(app) {
 			want = subscribeForbiddenMessageForApp(app)
 		}
 		assertMessage(t, msg, want)
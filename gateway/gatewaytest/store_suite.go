@@ -0,0 +1,254 @@
+// Package gatewaytest provides helpers to test gateway.Store implementations.
+package gatewaytest
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/mgxian/ws-gateway/gateway"
+)
+
+const (
+	publicApp        = "match"
+	authenticatedApp = "vip"
+	privateApp       = "inbox"
+	// defaultPrivateApp is public in registry of suite, stores must not
+	// assume visibility of apps
+	defaultPrivateApp = "im"
+)
+
+// StubConn implements gateway.Conn, messages written are dropped
+type StubConn struct {
+	id   string
+	addr string
+}
+
+// NewStubConn create a new StubConn
+func NewStubConn(id, addr string) *StubConn {
+	return &StubConn{id: id, addr: addr}
+}
+
+// ID returns id of connection
+func (c *StubConn) ID() string {
+	return c.id
+}
+
+// ReadMessage returns nothing
+func (c *StubConn) ReadMessage() ([]byte, error) {
+	return nil, nil
+}
+
+// WriteMessage drops msg
+func (c *StubConn) WriteMessage(msg []byte) error {
+	return nil
+}
+
+// RemoteAddr returns addr
+func (c *StubConn) RemoteAddr() string {
+	return c.addr
+}
+
+// RunStoreSuite verifies save/delete/lookup/count semantics of gateway.Store,
+// newStore must return an empty store routing connections by visibility of
+// apps in registry on each call.
+func RunStoreSuite(t *testing.T, newStore func(apps *gateway.AppRegistry) gateway.Store) {
+	ctx := context.Background()
+	apps := gateway.NewAppRegistry(
+		gateway.AppConfig{Name: authenticatedApp, Visibility: gateway.AuthenticatedApp},
+		gateway.AppConfig{Name: privateApp, Visibility: gateway.PrivateApp},
+		gateway.AppConfig{Name: defaultPrivateApp, Visibility: gateway.PublicApp},
+	)
+
+	t.Run("save public connection", func(t *testing.T) {
+		store := newStore(apps)
+		conn1 := NewStubConn("conn-1", "127.0.0.1:1001")
+		conn2 := NewStubConn("conn-2", "127.0.0.1:1002")
+		mustSave(t, store, publicApp, -1, conn1)
+		mustSave(t, store, publicApp, 123, conn2)
+
+		assertConnIDs(t, mustPublicConns(t, store, publicApp), "conn-1", "conn-2")
+		assertConnIDs(t, mustPublicConns(t, store, "unknown"))
+	})
+
+	t.Run("save connection of app public in registry", func(t *testing.T) {
+		store := newStore(apps)
+		mustSave(t, store, authenticatedApp, 123, NewStubConn("conn-1", "127.0.0.1:1001"))
+		mustSave(t, store, authenticatedApp, 456, NewStubConn("conn-2", "127.0.0.1:1002"))
+		mustSave(t, store, defaultPrivateApp, 123, NewStubConn("conn-3", "127.0.0.1:1003"))
+
+		assertConnIDs(t, mustPublicConns(t, store, authenticatedApp), "conn-1", "conn-2")
+		assertConnIDs(t, mustPublicConns(t, store, defaultPrivateApp), "conn-3")
+		assertConnIDs(t, mustPrivateConns(t, store, defaultPrivateApp, 123))
+	})
+
+	t.Run("save private connection", func(t *testing.T) {
+		store := newStore(apps)
+		mustSave(t, store, privateApp, 123, NewStubConn("conn-1", "127.0.0.1:1001"))
+		mustSave(t, store, privateApp, 123, NewStubConn("conn-2", "127.0.0.1:1002"))
+		mustSave(t, store, privateApp, 456, NewStubConn("conn-3", "127.0.0.1:1003"))
+		mustSave(t, store, privateApp, -1, NewStubConn("conn-4", "127.0.0.1:1004"))
+
+		assertConnIDs(t, mustPrivateConns(t, store, privateApp, 123), "conn-1", "conn-2")
+		assertConnIDs(t, mustPrivateConns(t, store, privateApp, 456), "conn-3")
+		assertConnIDs(t, mustPrivateConns(t, store, privateApp, -1))
+		assertStrings(t, mustSubscriptions(t, store, "conn-4"))
+	})
+
+	t.Run("save connection twice", func(t *testing.T) {
+		store := newStore(apps)
+		conn := NewStubConn("conn-1", "127.0.0.1:1001")
+		mustSave(t, store, publicApp, 0, conn)
+		mustSave(t, store, publicApp, 0, conn)
+
+		assertConnIDs(t, mustPublicConns(t, store, publicApp), "conn-1")
+	})
+
+	t.Run("connections with same remote addr", func(t *testing.T) {
+		store := newStore(apps)
+		mustSave(t, store, publicApp, 0, NewStubConn("conn-1", "127.0.0.1:1001"))
+		mustSave(t, store, publicApp, 0, NewStubConn("conn-2", "127.0.0.1:1001"))
+
+		assertConnIDs(t, mustPublicConns(t, store, publicApp), "conn-1", "conn-2")
+	})
+
+	t.Run("delete connection from all apps", func(t *testing.T) {
+		store := newStore(apps)
+		conn1 := NewStubConn("conn-1", "127.0.0.1:1001")
+		conn2 := NewStubConn("conn-2", "127.0.0.1:1002")
+		mustSave(t, store, publicApp, 123, conn1)
+		mustSave(t, store, privateApp, 123, conn1)
+		mustSave(t, store, publicApp, 0, conn2)
+
+		if err := store.Delete(ctx, 123, conn1); err != nil {
+			t.Fatalf("delete failed: %v", err)
+		}
+
+		assertConnIDs(t, mustPublicConns(t, store, publicApp), "conn-2")
+		assertConnIDs(t, mustPrivateConns(t, store, privateApp, 123))
+		assertStrings(t, mustSubscriptions(t, store, "conn-1"))
+	})
+
+	t.Run("delete unknown connection", func(t *testing.T) {
+		store := newStore(apps)
+		if err := store.Delete(ctx, 123, NewStubConn("conn-1", "127.0.0.1:1001")); err != nil {
+			t.Fatalf("delete failed: %v", err)
+		}
+	})
+
+	t.Run("delete connection for app", func(t *testing.T) {
+		store := newStore(apps)
+		conn := NewStubConn("conn-1", "127.0.0.1:1001")
+		mustSave(t, store, publicApp, 123, conn)
+		mustSave(t, store, privateApp, 123, conn)
+
+		if err := store.DeleteForApp(ctx, publicApp, 123, conn); err != nil {
+			t.Fatalf("delete for app failed: %v", err)
+		}
+
+		assertConnIDs(t, mustPublicConns(t, store, publicApp))
+		assertConnIDs(t, mustPrivateConns(t, store, privateApp, 123), "conn-1")
+		assertStrings(t, mustSubscriptions(t, store, "conn-1"), privateApp)
+	})
+
+	t.Run("subscriptions", func(t *testing.T) {
+		store := newStore(apps)
+		conn := NewStubConn("conn-1", "127.0.0.1:1001")
+		mustSave(t, store, publicApp, 123, conn)
+		mustSave(t, store, privateApp, 123, conn)
+		mustSave(t, store, "chat", 123, conn)
+
+		assertStrings(t, mustSubscriptions(t, store, "conn-1"), "chat", privateApp, publicApp)
+		assertStrings(t, mustSubscriptions(t, store, "unknown"))
+	})
+
+	t.Run("apps", func(t *testing.T) {
+		store := newStore(apps)
+		mustSave(t, store, publicApp, 0, NewStubConn("conn-1", "127.0.0.1:1001"))
+		mustSave(t, store, privateApp, 123, NewStubConn("conn-2", "127.0.0.1:1002"))
+
+		apps, err := store.Apps(ctx)
+		if err != nil {
+			t.Fatalf("apps failed: %v", err)
+		}
+		sort.Strings(apps)
+		assertStrings(t, apps, privateApp, publicApp)
+	})
+
+	t.Run("apps conn count", func(t *testing.T) {
+		store := newStore(apps)
+		mustSave(t, store, publicApp, 0, NewStubConn("conn-1", "127.0.0.1:1001"))
+		mustSave(t, store, publicApp, 0, NewStubConn("conn-2", "127.0.0.1:1002"))
+		mustSave(t, store, privateApp, 123, NewStubConn("conn-3", "127.0.0.1:1003"))
+		mustSave(t, store, privateApp, 123, NewStubConn("conn-4", "127.0.0.1:1004"))
+		mustSave(t, store, privateApp, 456, NewStubConn("conn-5", "127.0.0.1:1005"))
+
+		counts, err := store.AppsConnCount(ctx)
+		if err != nil {
+			t.Fatalf("apps conn count failed: %v", err)
+		}
+		got := make(map[string]int)
+		for _, c := range counts {
+			got[c.App] = c.Count
+		}
+		want := map[string]int{publicApp: 2, privateApp: 2}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+}
+
+func mustSave(t *testing.T, store gateway.Store, app string, memberID int, conn gateway.Conn) {
+	t.Helper()
+	if err := store.Save(context.Background(), app, memberID, conn); err != nil {
+		t.Fatalf("save %s to %s failed: %v", conn.ID(), app, err)
+	}
+}
+
+func mustPublicConns(t *testing.T, store gateway.Store, app string) []gateway.Conn {
+	t.Helper()
+	conns, err := store.PublicConns(context.Background(), app)
+	if err != nil {
+		t.Fatalf("public conns of %s failed: %v", app, err)
+	}
+	return conns
+}
+
+func mustPrivateConns(t *testing.T, store gateway.Store, app string, memberID int) []gateway.Conn {
+	t.Helper()
+	conns, err := store.PrivateConns(context.Background(), app, memberID)
+	if err != nil {
+		t.Fatalf("private conns of %s for %d failed: %v", app, memberID, err)
+	}
+	return conns
+}
+
+func mustSubscriptions(t *testing.T, store gateway.Store, connID string) []string {
+	t.Helper()
+	apps, err := store.Subscriptions(context.Background(), connID)
+	if err != nil {
+		t.Fatalf("subscriptions of %s failed: %v", connID, err)
+	}
+	return apps
+}
+
+func assertConnIDs(t *testing.T, conns []gateway.Conn, want ...string) {
+	t.Helper()
+	got := make([]string, 0, len(conns))
+	for _, conn := range conns {
+		got = append(got, conn.ID())
+	}
+	sort.Strings(got)
+	assertStrings(t, got, want...)
+}
+
+func assertStrings(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) == 0 && len(want) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
@@ -0,0 +1,13 @@
+package gatewaytest
+
+import (
+	"testing"
+
+	"github.com/mgxian/ws-gateway/gateway"
+)
+
+func TestInMemeryWSClientStore(t *testing.T) {
+	RunStoreSuite(t, func(apps *gateway.AppRegistry) gateway.Store {
+		return gateway.NewInMemeryWSClientStoreForApps(apps)
+	})
+}
@@ -0,0 +1,65 @@
+package gateway
+
+import (
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultPingInterval = time.Second * 30
+	defaultPongWait     = time.Second * 60
+)
+
+// heartbeatConfig websocket ping interval and pong deadline, heartbeat is
+// disabled when pingInterval is 0
+type heartbeatConfig struct {
+	pingInterval time.Duration
+	pongWait     time.Duration
+}
+
+func defaultHeartbeatConfig() heartbeatConfig {
+	return heartbeatConfig{
+		pingInterval: defaultPingInterval,
+		pongWait:     defaultPongWait,
+	}
+}
+
+func (c heartbeatConfig) enabled() bool {
+	return c.pingInterval > 0
+}
+
+// extendReadDeadline extend read deadline after any frame received from client,
+// so application level ping keeps connection alive too
+func (ws *wsConn) extendReadDeadline() error {
+	if !ws.heartbeat.enabled() {
+		return nil
+	}
+	return ws.conn.SetReadDeadline(time.Now().Add(ws.heartbeat.pongWait))
+}
+
+// pingLoop send websocket ping periodically until connection closed
+func (ws *wsConn) pingLoop() {
+	ticker := time.NewTicker(ws.heartbeat.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := ws.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(ws.writeWait)); err != nil {
+				return
+			}
+		case <-ws.closed:
+			return
+		case <-ws.done:
+			return
+		}
+	}
+}
+
+// isHeartbeatTimeout check if read failed because client missed pong deadline
+func isHeartbeatTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
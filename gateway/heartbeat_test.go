@@ -0,0 +1,66 @@
+package gateway
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	newHeartbeatServer := func() (*httptest.Server, *Server, *InMemeryWSClientStore) {
+		store := NewInMemeryWSClientStore()
+		gateway := NewGatewayServer(store, &FakeAuthServer{}, WithHeartbeat(time.Millisecond*20, time.Millisecond*60))
+		return httptest.NewServer(gateway), gateway, store
+	}
+
+	t.Run("dead connection is reaped", func(t *testing.T) {
+		server, gateway, store := newHeartbeatServer()
+		defer server.Close()
+
+		ws := mustConnectAndAuthAndSubscribe(t, server, anonymousMemberID, "", "match")
+		defer ws.Close()
+		assertWSClientCount(t, len(store.publicWSClientsForApp("match")), 1)
+
+		time.Sleep(time.Millisecond * 200)
+		assertWSClientCount(t, len(store.publicWSClientsForApp("match")), 0)
+		assertEqual(t, gateway.counters()[0], wsCount{"heartbeat_timeouts", 1})
+	})
+
+	t.Run("client answering ping is kept", func(t *testing.T) {
+		server, gateway, store := newHeartbeatServer()
+		defer server.Close()
+
+		ws := mustConnectAndAuthAndSubscribe(t, server, anonymousMemberID, "", "match")
+		defer ws.Close()
+
+		_, err := readMessageWithTimeout(ws, time.Millisecond*200)
+		assertError(t, err)
+		assertWSClientCount(t, len(store.publicWSClientsForApp("match")), 1)
+		assertEqual(t, gateway.counters()[0], wsCount{"heartbeat_timeouts", 0})
+	})
+
+	t.Run("application ping keeps connection", func(t *testing.T) {
+		server, _, store := newHeartbeatServer()
+		defer server.Close()
+
+		ws := mustConnectAndAuthAndSubscribe(t, server, anonymousMemberID, "", "match")
+		defer ws.Close()
+
+		for i := 0; i < 10; i++ {
+			mustWriteMessage(t, ws, `{"action":"ping"}`)
+			time.Sleep(time.Millisecond * 20)
+		}
+		assertWSClientCount(t, len(store.publicWSClientsForApp("match")), 1)
+	})
+}
+
+func TestStatServerCounters(t *testing.T) {
+	store := NewInMemeryWSClientStore()
+	gateway := NewGatewayServer(store, &FakeAuthServer{})
+	statServer := NewStatServer(store, gateway)
+
+	response := httptest.NewRecorder()
+	statServer.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	assertEqual(t, strings.Contains(response.Body.String(), "heartbeat_timeouts 0\n"), true)
+}
@@ -0,0 +1,112 @@
+package gateway
+
+import (
+	"sync"
+	"time"
+)
+
+// replayRequest history client asks for when subscribing, messages after
+// sequence number since and at most last messages
+type replayRequest struct {
+	since *uint64
+	last  int
+}
+
+func (r replayRequest) requested() bool {
+	return r.since != nil || r.last > 0
+}
+
+type historyEntry struct {
+	pushMsg *PushMessage
+	at      time.Time
+}
+
+// appHistory ring buffer of recent push messages of an app, sequence numbers
+// are assigned by this node
+type appHistory struct {
+	mu        sync.Mutex
+	size      int
+	retention time.Duration
+	seq       uint64
+	entries   []historyEntry
+	next      int
+}
+
+func newAppHistory(size int, retention time.Duration) *appHistory {
+	return &appHistory{
+		size:      size,
+		retention: retention,
+		entries:   make([]historyEntry, 0, size),
+	}
+}
+
+// recordLocked assign next sequence number to push message and keep it,
+// oldest message is evicted when buffer is full, must hold h.mu
+func (h *appHistory) recordLocked(pushMsg *PushMessage) {
+	h.seq++
+	pushMsg.Seq = h.seq
+
+	stored := *pushMsg
+	stored.done = nil
+	entry := historyEntry{pushMsg: &stored, at: time.Now()}
+	if len(h.entries) < h.size {
+		h.entries = append(h.entries, entry)
+		return
+	}
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % h.size
+}
+
+// replayLocked returns retained messages matching request in sequence order,
+// messages of private app are only replayed to their member, since beyond
+// latest sequence number comes from before a restart and replays all
+// retained messages, must hold h.mu
+func (h *appHistory) replayLocked(req replayRequest, memberID int, private bool) []*PushMessage {
+	if req.since != nil && *req.since > h.seq {
+		req.since = nil
+	}
+
+	var cutoff time.Time
+	if h.retention > 0 {
+		cutoff = time.Now().Add(-h.retention)
+	}
+
+	var result []*PushMessage
+	ordered := append(append([]historyEntry(nil), h.entries[h.next:]...), h.entries[:h.next]...)
+	for _, entry := range ordered {
+		if entry.at.Before(cutoff) {
+			continue
+		}
+		if req.since != nil && entry.pushMsg.Seq <= *req.since {
+			continue
+		}
+		if private && entry.pushMsg.MemberID != memberID {
+			continue
+		}
+		result = append(result, entry.pushMsg)
+	}
+
+	if req.last > 0 && len(result) > req.last {
+		result = result[len(result)-req.last:]
+	}
+	return result
+}
+
+// messageHistory histories of apps configured with history size in apps
+type messageHistory struct {
+	apps      *AppRegistry
+	histories sync.Map
+}
+
+// forApp returns history of app, nil when app keeps no history
+func (mh *messageHistory) forApp(app string) *appHistory {
+	if v, ok := mh.histories.Load(app); ok {
+		return v.(*appHistory)
+	}
+	config := mh.apps.App(app)
+	if config.HistorySize <= 0 {
+		return nil
+	}
+	v, _ := mh.histories.LoadOrStore(app, newAppHistory(config.HistorySize, config.HistoryRetention))
+	return v.(*appHistory)
+}
@@ -0,0 +1,158 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func replayTexts(messages []*PushMessage) []string {
+	var result []string
+	for _, pushMsg := range messages {
+		result = append(result, fmt.Sprintf("%d:%s", pushMsg.Seq, pushMsg.Text))
+	}
+	return result
+}
+
+func sinceSeq(seq uint64) *uint64 {
+	return &seq
+}
+
+func TestAppHistory(t *testing.T) {
+	history := newAppHistory(3, 0)
+	for i := 1; i <= 5; i++ {
+		history.recordLocked(&PushMessage{App: "match", MemberID: anonymousMemberID, Text: fmt.Sprint(i)})
+	}
+
+	tests := []struct {
+		description string
+		req         replayRequest
+		want        []string
+	}{
+		{"oldest messages are evicted", replayRequest{since: sinceSeq(0)}, []string{"3:3", "4:4", "5:5"}},
+		{"since", replayRequest{since: sinceSeq(3)}, []string{"4:4", "5:5"}},
+		{"since latest", replayRequest{since: sinceSeq(5)}, nil},
+		{"since before restart", replayRequest{since: sinceSeq(100)}, []string{"3:3", "4:4", "5:5"}},
+		{"last", replayRequest{last: 2}, []string{"4:4", "5:5"}},
+		{"last beyond size", replayRequest{last: 10}, []string{"3:3", "4:4", "5:5"}},
+		{"since and last", replayRequest{since: sinceSeq(2), last: 1}, []string{"5:5"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			assertEqual(t, replayTexts(history.replayLocked(tt.req, anonymousMemberID, false)), tt.want)
+		})
+	}
+
+	t.Run("private messages are replayed to their member", func(t *testing.T) {
+		history := newAppHistory(10, 0)
+		history.recordLocked(&PushMessage{App: imApp, MemberID: 1, Text: "to 1"})
+		history.recordLocked(&PushMessage{App: imApp, MemberID: 2, Text: "to 2"})
+		assertEqual(t, replayTexts(history.replayLocked(replayRequest{last: 10}, 2, true)), []string{"2:to 2"})
+	})
+
+	t.Run("expired messages are not replayed", func(t *testing.T) {
+		history := newAppHistory(10, time.Millisecond*20)
+		history.recordLocked(&PushMessage{App: "match", Text: "old"})
+		time.Sleep(time.Millisecond * 30)
+		history.recordLocked(&PushMessage{App: "match", Text: "new"})
+		assertEqual(t, replayTexts(history.replayLocked(replayRequest{last: 10}, anonymousMemberID, false)), []string{"2:new"})
+	})
+}
+
+func TestReplayOnSubscribe(t *testing.T) {
+	historyApp := "score-history"
+	privateHistoryApp := "inbox-history"
+	registry := NewAppRegistry(
+		AppConfig{Name: historyApp, Visibility: PublicApp, HistorySize: 3},
+		AppConfig{Name: privateHistoryApp, Visibility: PrivateApp, HistorySize: 3},
+	)
+
+	gateway := NewGatewayServer(NewInMemeryWSClientStoreForApps(registry), &FakeAuthServer{}, WithAppRegistry(registry))
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	publish := func(app string, memberID int, text string) {
+		assertNoError(t, gateway.publishAndWait(context.Background(), &PushMessage{App: app, MemberID: memberID, Text: text}))
+	}
+	for i := 1; i <= 4; i++ {
+		publish(historyApp, anonymousMemberID, fmt.Sprint(i))
+	}
+	publish(privateHistoryApp, 123456, "mine")
+	publish(privateHistoryApp, 999, "not mine")
+
+	t.Run("last", func(t *testing.T) {
+		ws := mustConnectAndAuthAndSubscribe(t, server, 123456, "654321", "match")
+		defer ws.Close()
+
+		mustWriteMessage(t, ws, fmt.Sprintf(`{"app":"%s","last":2}`, historyApp))
+		assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*100), wrapGatewayResponseMessage(subscribeSuccessText(historyApp)))
+		assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*100), `{"app":"score-history","member_id":-1,"text":"3","seq":3}`)
+		assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*100), `{"app":"score-history","member_id":-1,"text":"4","seq":4}`)
+
+		publish(historyApp, anonymousMemberID, "5")
+		assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*100), `{"app":"score-history","member_id":-1,"text":"5","seq":5}`)
+	})
+
+	t.Run("since in envelope", func(t *testing.T) {
+		ws := mustConnectAndAuthAndSubscribe(t, server, 123456, "654321", "match")
+		defer ws.Close()
+
+		mustWriteMessage(t, ws, fmt.Sprintf(`{"v":1,"id":"s1","action":"subscribe","data":{"app":"%s","since":4}}`, historyApp))
+		assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*100), wrapGatewayReply("s1", subscribeSuccessText(historyApp)))
+		assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*100), `{"app":"score-history","member_id":-1,"text":"5","seq":5}`)
+		_, err := readMessageWithTimeout(ws, time.Millisecond*20)
+		assertError(t, err)
+	})
+
+	t.Run("private history of member", func(t *testing.T) {
+		ws := mustConnectAndAuthAndSubscribe(t, server, 123456, "654321", "match")
+		defer ws.Close()
+
+		mustWriteMessage(t, ws, fmt.Sprintf(`{"app":"%s","last":3}`, privateHistoryApp))
+		assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*100), wrapGatewayResponseMessage(subscribeSuccessText(privateHistoryApp)))
+		assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*100), `{"app":"inbox-history","member_id":123456,"text":"mine","seq":1}`)
+		_, err := readMessageWithTimeout(ws, time.Millisecond*20)
+		assertError(t, err)
+	})
+
+	t.Run("subscribe without replay", func(t *testing.T) {
+		ws := mustConnectAndAuthAndSubscribe(t, server, 123456, "654321", historyApp)
+		defer ws.Close()
+
+		_, err := readMessageWithTimeout(ws, time.Millisecond*20)
+		assertError(t, err)
+	})
+}
+
+func TestReplayCappedBySendQueue(t *testing.T) {
+	historyApp := "score-history"
+	registry := NewAppRegistry(AppConfig{Name: historyApp, Visibility: PublicApp, HistorySize: 20})
+	gateway := NewGatewayServer(NewInMemeryWSClientStoreForApps(registry), &FakeAuthServer{},
+		WithAppRegistry(registry), WithSendQueueSize(4), WithSlowConsumerPolicy(Disconnect))
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	for i := 1; i <= 20; i++ {
+		assertNoError(t, gateway.publishAndWait(context.Background(), &PushMessage{App: historyApp, MemberID: anonymousMemberID, Text: fmt.Sprint(i)}))
+	}
+
+	ws := mustConnectAndAuthAndSubscribe(t, server, 123456, "654321", "match")
+	defer ws.Close()
+	mustWriteMessage(t, ws, fmt.Sprintf(`{"app":"%s","last":20}`, historyApp))
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*100), wrapGatewayResponseMessage(subscribeSuccessText(historyApp)))
+
+	latest := `{"app":"score-history","member_id":-1,"text":"20","seq":20}`
+	replayed := 0
+	for msg := ""; msg != latest; replayed++ {
+		if replayed == 4 {
+			t.Fatal("replay is not capped by send queue size")
+		}
+		msg = mustReadMessageWithTimeout(t, ws, time.Millisecond*100)
+	}
+
+	mustSendSubscribeMessage(t, ws, imApp)
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*100), subscribeSuccessMessageForApp(imApp))
+}
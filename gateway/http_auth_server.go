@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultHTTPAuthTimeout       = time.Second * 3
+	defaultHTTPAuthRetryInterval = time.Millisecond * 100
+)
+
+// HTTPAuthServerConfig configure HTTPAuthServer
+type HTTPAuthServerConfig struct {
+	// URL member service auth endpoint
+	URL string
+	// Timeout timeout of every auth request
+	Timeout time.Duration
+	// Retries retry count when member service is not available
+	Retries int
+	// RetryInterval wait time between retries
+	RetryInterval time.Duration
+	// FailOpen treat member as authed when member service is not available
+	FailOpen bool
+}
+
+// HTTPAuthServer implements AuthServer by calling member service
+type HTTPAuthServer struct {
+	config HTTPAuthServerConfig
+	client *http.Client
+}
+
+// NewHTTPAuthServer create a new HTTPAuthServer
+func NewHTTPAuthServer(config HTTPAuthServerConfig) *HTTPAuthServer {
+	if config.Timeout <= 0 {
+		config.Timeout = defaultHTTPAuthTimeout
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = defaultHTTPAuthRetryInterval
+	}
+	if config.Retries < 0 {
+		config.Retries = 0
+	}
+	return &HTTPAuthServer{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// Auth check if member authed by member service
+func (s *HTTPAuthServer) Auth(memberID int, token string) bool {
+	_, err := s.Authenticate(context.Background(), AuthMessage{MemberID: memberID, Token: token})
+	return err == nil
+}
+
+// Authenticate check member by member service, 400, 401, 403, 404 and 422
+// responses reject member, returns ErrAuthUnavailable on other responses
+// like 429 or 5xx, or when request fails or times out, and FailOpen is not set
+func (s *HTTPAuthServer) Authenticate(ctx context.Context, auth AuthMessage) (Identity, error) {
+	result, err := s.auth(ctx, auth)
+	if err != nil {
+		log.Printf("auth member %d failed: %v", auth.MemberID, err)
+		if s.config.FailOpen {
+			return Identity{MemberID: auth.MemberID}, nil
+		}
+		return Identity{}, ErrAuthUnavailable
+	}
+	if !result.authed {
+		return Identity{}, ErrUnauthorized
+	}
+
+	identity := Identity{MemberID: auth.MemberID}
+	if result.ExpiresIn > 0 {
+		identity.ExpiresAt = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	}
+	return identity, nil
+}
+
+// httpAuthResult member service auth response, expires_in is optional
+// seconds before member credentials expire
+type httpAuthResult struct {
+	authed    bool
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+func (s *HTTPAuthServer) auth(ctx context.Context, auth AuthMessage) (result httpAuthResult, err error) {
+	body, _ := json.Marshal(auth)
+	for i := 0; i <= s.config.Retries; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(s.config.RetryInterval):
+			case <-ctx.Done():
+				return result, ctx.Err()
+			}
+		}
+		result, err = s.doAuthRequest(ctx, body)
+		if err == nil {
+			return result, nil
+		}
+	}
+	return result, err
+}
+
+func (s *HTTPAuthServer) doAuthRequest(ctx context.Context, body []byte) (result httpAuthResult, err error) {
+	request, err := http.NewRequest(http.MethodPost, s.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return result, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := s.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return result, err
+	}
+	defer response.Body.Close()
+	responseBody, _ := ioutil.ReadAll(response.Body)
+
+	switch {
+	case response.StatusCode == http.StatusOK || response.StatusCode == http.StatusNoContent:
+		json.Unmarshal(responseBody, &result)
+		result.authed = true
+		return result, nil
+	case response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden:
+		return result, nil
+	case response.StatusCode == http.StatusBadRequest || response.StatusCode == http.StatusNotFound ||
+		response.StatusCode == http.StatusUnprocessableEntity:
+		// member service rejects the request, which must not fail open
+		log.Printf("member service rejected auth request with status %d", response.StatusCode)
+		return result, nil
+	}
+	return result, fmt.Errorf("member service response status %d", response.StatusCode)
+}
@@ -0,0 +1,157 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPAuthServer(t *testing.T) {
+	memberService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var authMsg AuthMessage
+		if err := json.NewDecoder(r.Body).Decode(&authMsg); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if authMsg.MemberID == 123456 && authMsg.Token == "654321" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer memberService.Close()
+
+	authServer := NewHTTPAuthServer(HTTPAuthServerConfig{URL: memberService.URL})
+
+	tests := []struct {
+		description string
+		memberID    int
+		token       string
+		want        bool
+	}{
+		{"valid member", 123456, "654321", true},
+		{"wrong token", 123456, "000000", false},
+		{"unknown member", 12345, "654321", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			assertEqual(t, authServer.Auth(tt.memberID, tt.token), tt.want)
+		})
+	}
+}
+
+func TestHTTPAuthServerRetry(t *testing.T) {
+	var requestCount int32
+	memberService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requestCount, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer memberService.Close()
+
+	authServer := NewHTTPAuthServer(HTTPAuthServerConfig{
+		URL:           memberService.URL,
+		Retries:       2,
+		RetryInterval: time.Millisecond,
+	})
+
+	assertEqual(t, authServer.Auth(123456, "654321"), true)
+	assertEqual(t, atomic.LoadInt32(&requestCount), int32(3))
+}
+
+func TestHTTPAuthServerUnavailable(t *testing.T) {
+	memberService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond * 50)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer memberService.Close()
+
+	tests := []struct {
+		description string
+		failOpen    bool
+		want        bool
+	}{
+		{"fail closed", false, false},
+		{"fail open", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			authServer := NewHTTPAuthServer(HTTPAuthServerConfig{
+				URL:           memberService.URL,
+				Timeout:       time.Millisecond * 10,
+				Retries:       1,
+				RetryInterval: time.Millisecond,
+				FailOpen:      tt.failOpen,
+			})
+			assertEqual(t, authServer.Auth(123456, "654321"), tt.want)
+		})
+	}
+}
+
+func TestHTTPAuthServerThrottledFailOpen(t *testing.T) {
+	tests := []struct {
+		description string
+		status      int
+	}{
+		{"too many requests", http.StatusTooManyRequests},
+		{"request timeout", http.StatusRequestTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			memberService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer memberService.Close()
+
+			config := HTTPAuthServerConfig{URL: memberService.URL, RetryInterval: time.Millisecond}
+			_, err := NewHTTPAuthServer(config).Authenticate(context.Background(), AuthMessage{MemberID: 123456, Token: "654321"})
+			assertEqual(t, err, ErrAuthUnavailable)
+
+			config.FailOpen = true
+			identity, err := NewHTTPAuthServer(config).Authenticate(context.Background(), AuthMessage{MemberID: 123456, Token: "654321"})
+			assertNoError(t, err)
+			assertEqual(t, identity.MemberID, 123456)
+		})
+	}
+}
+
+func TestHTTPAuthServerClientErrorNotFailOpen(t *testing.T) {
+	tests := []struct {
+		description string
+		status      int
+	}{
+		{"bad request", http.StatusBadRequest},
+		{"not found", http.StatusNotFound},
+		{"unprocessable entity", http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			var requestCount int32
+			memberService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requestCount, 1)
+				w.WriteHeader(tt.status)
+			}))
+			defer memberService.Close()
+
+			authServer := NewHTTPAuthServer(HTTPAuthServerConfig{
+				URL:           memberService.URL,
+				Retries:       2,
+				RetryInterval: time.Millisecond,
+				FailOpen:      true,
+			})
+			_, err := authServer.Authenticate(context.Background(), AuthMessage{MemberID: 123456, Token: "654321"})
+			assertEqual(t, err, ErrUnauthorized)
+			assertEqual(t, atomic.LoadInt32(&requestCount), int32(1))
+		})
+	}
+}
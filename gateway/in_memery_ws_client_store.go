@@ -1,6 +1,8 @@
 package gateway
 
 import (
+	"context"
+	"sort"
 	"sync"
 
 	cmap "github.com/orcaman/concurrent-map"
@@ -10,8 +12,6 @@ const (
 	publicAppMemberID = 0
 )
 
-type remoteAddr = string
-
 // memberWSClients store websocket connections of member
 type memberWSClients struct {
 	memberID int
@@ -26,15 +26,14 @@ func newMemberWSClients(memberID int) *memberWSClients {
 	}
 }
 
-// save store websocket connection of member
+// save store websocket connection of member by connection id
 func (m *memberWSClients) save(ws Conn) error {
-	addr := ws.RemoteAddr()
-	m.wsConns.Set(addr, ws)
+	m.wsConns.Set(ws.ID(), ws)
 	return nil
 }
 
 func (m *memberWSClients) delete(ws Conn) {
-	m.wsConns.Remove(ws.RemoteAddr())
+	m.wsConns.Remove(ws.ID())
 }
 
 // wsClients return websocket connections of member
@@ -46,6 +45,40 @@ func (m *memberWSClients) wsClients() []Conn {
 	return result
 }
 
+// isEmpty check if member has no websocket connection
+func (m *memberWSClients) isEmpty() bool {
+	return m.wsConns.Count() == 0
+}
+
+// connSubscriptions apps subscribed by a connection and member id
+// connection is stored under for each app
+type connSubscriptions struct {
+	mu   sync.Mutex
+	apps map[string]int
+}
+
+func (cs *connSubscriptions) add(app string, memberID int) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.apps[app] = memberID
+}
+
+func (cs *connSubscriptions) remove(app string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	delete(cs.apps, app)
+}
+
+func (cs *connSubscriptions) list() map[string]int {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	result := make(map[string]int, len(cs.apps))
+	for app, memberID := range cs.apps {
+		result[app] = memberID
+	}
+	return result
+}
+
 // appWSClients store websocket connections of app
 type appWSClients struct {
 	name          string
@@ -74,7 +107,7 @@ func (app *appWSClients) delete(memberID int, ws Conn) {
 	if v, ok := app.memberClients.Load(memberID); ok {
 		mcs := v.(*memberWSClients)
 		mcs.delete(ws)
-		if len(mcs.wsClients()) == 0 {
+		if mcs.isEmpty() {
 			app.memberClients.Delete(memberID)
 		}
 	}
@@ -90,27 +123,38 @@ func (app *appWSClients) wsClientsForMember(memberID int) []Conn {
 	return mcs.wsClients()
 }
 
-// InMemeryWSClientStore store websocket connection
+// InMemeryWSClientStore store websocket connection, connSubscriptions
+// index apps subscribed by each connection id
 type InMemeryWSClientStore struct {
-	appClients sync.Map
+	registry          *AppRegistry
+	appClients        sync.Map
+	connSubscriptions sync.Map
 }
 
-// NewInMemeryWSClientStore create a new WSClientStore
+// NewInMemeryWSClientStore create a new WSClientStore for apps of DefaultAppRegistry
 func NewInMemeryWSClientStore() *InMemeryWSClientStore {
-	store := &InMemeryWSClientStore{}
-	return store
+	return NewInMemeryWSClientStoreForApps(DefaultAppRegistry())
+}
+
+// NewInMemeryWSClientStoreForApps create a new WSClientStore routing connections
+// by visibility of apps in registry, which should be the registry of server
+func NewInMemeryWSClientStoreForApps(registry *AppRegistry) *InMemeryWSClientStore {
+	return &InMemeryWSClientStore{registry: registry}
 }
 
 // Save store websocket connection
 func (wcs *InMemeryWSClientStore) save(app string, memberID int, ws Conn) error {
-	if !isPrivateApp(app) {
+	if !wcs.registry.isPrivate(app) {
 		memberID = publicAppMemberID
 	}
 
-	if isPrivateApp(app) && !isValidMemberID(memberID) {
+	if wcs.registry.isPrivate(app) && !isValidMemberID(memberID) {
 		return nil
 	}
 
+	v, _ := wcs.connSubscriptions.LoadOrStore(ws.ID(), &connSubscriptions{apps: make(map[string]int)})
+	v.(*connSubscriptions).add(app, memberID)
+
 	v, ok := wcs.appClients.Load(app)
 	if !ok {
 		appWSClient := newAPPWSClients(app)
@@ -122,18 +166,53 @@ func (wcs *InMemeryWSClientStore) save(app string, memberID int, ws Conn) error
 	return appWSClient.save(memberID, ws)
 }
 
+// delete websocket connection from apps it subscribed
 func (wcs *InMemeryWSClientStore) delete(memberID int, ws Conn) {
-	wcs.appClients.Range(func(k, v interface{}) bool {
-		mid := memberID
-		if !isPrivateApp(k.(string)) {
-			mid = publicAppMemberID
-		}
-		appWSClient, ok := v.(*appWSClients)
-		if ok {
-			appWSClient.delete(mid, ws)
+	v, ok := wcs.connSubscriptions.Load(ws.ID())
+	if !ok {
+		return
+	}
+	wcs.connSubscriptions.Delete(ws.ID())
+
+	for app, mid := range v.(*connSubscriptions).list() {
+		if v, ok := wcs.appClients.Load(app); ok {
+			v.(*appWSClients).delete(mid, ws)
 		}
-		return true
-	})
+	}
+}
+
+// deleteForApp delete websocket connection from app
+func (wcs *InMemeryWSClientStore) deleteForApp(app string, memberID int, ws Conn) {
+	if !wcs.registry.isPrivate(app) {
+		memberID = publicAppMemberID
+	}
+
+	if v, ok := wcs.connSubscriptions.Load(ws.ID()); ok {
+		v.(*connSubscriptions).remove(app)
+	}
+
+	v, ok := wcs.appClients.Load(app)
+	if !ok {
+		return
+	}
+	if appWSClient, ok := v.(*appWSClients); ok {
+		appWSClient.delete(memberID, ws)
+	}
+}
+
+// subscriptions returns sorted apps subscribed by connection connID
+func (wcs *InMemeryWSClientStore) subscriptions(connID string) []string {
+	v, ok := wcs.connSubscriptions.Load(connID)
+	if !ok {
+		return nil
+	}
+
+	var apps []string
+	for app := range v.(*connSubscriptions).list() {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+	return apps
 }
 
 // publicWSClientsForApp return public websocket connections for app
@@ -151,9 +230,8 @@ func (wcs *InMemeryWSClientStore) publicWSClientsForApp(app string) []Conn {
 	return appClient.wsClientsForMember(0)
 }
 
-// privateWSClientsForMember return private websocket connections for member
-func (wcs *InMemeryWSClientStore) privateWSClientsForMember(memberID int) []Conn {
-	app := imApp
+// privateWSClientsForMember return private websocket connections of app for member
+func (wcs *InMemeryWSClientStore) privateWSClientsForMember(app string, memberID int) []Conn {
 	v, ok := wcs.appClients.Load(app)
 	if !ok {
 		return nil
@@ -167,12 +245,12 @@ func (wcs *InMemeryWSClientStore) privateWSClientsForMember(memberID int) []Conn
 	return appClient.wsClientsForMember(memberID)
 }
 
-func (wcs *InMemeryWSClientStore) appsWSClientCount() []wsCount {
-	var result []wsCount
+func (wcs *InMemeryWSClientStore) appsWSClientCount() []AppConnCount {
+	var result []AppConnCount
 	wcs.appClients.Range(func(k, v interface{}) bool {
 		count := 0
 		app := k.(string)
-		if isPrivateApp(app) {
+		if wcs.registry.isPrivate(app) {
 			v, _ := wcs.appClients.Load(app)
 			appClients := v.(*appWSClients)
 			appClients.memberClients.Range(func(k, v interface{}) bool {
@@ -182,7 +260,7 @@ func (wcs *InMemeryWSClientStore) appsWSClientCount() []wsCount {
 		} else {
 			count = len(wcs.publicWSClientsForApp(app))
 		}
-		result = append(result, wsCount{app, count})
+		result = append(result, AppConnCount{app, count})
 		return true
 	})
 	return result
@@ -196,3 +274,45 @@ func (wcs *InMemeryWSClientStore) apps() []string {
 	})
 	return result
 }
+
+// Save implements Store
+func (wcs *InMemeryWSClientStore) Save(ctx context.Context, app string, memberID int, ws Conn) error {
+	return wcs.save(app, memberID, ws)
+}
+
+// Delete implements Store
+func (wcs *InMemeryWSClientStore) Delete(ctx context.Context, memberID int, ws Conn) error {
+	wcs.delete(memberID, ws)
+	return nil
+}
+
+// DeleteForApp implements Store
+func (wcs *InMemeryWSClientStore) DeleteForApp(ctx context.Context, app string, memberID int, ws Conn) error {
+	wcs.deleteForApp(app, memberID, ws)
+	return nil
+}
+
+// PublicConns implements Store
+func (wcs *InMemeryWSClientStore) PublicConns(ctx context.Context, app string) ([]Conn, error) {
+	return wcs.publicWSClientsForApp(app), nil
+}
+
+// PrivateConns implements Store
+func (wcs *InMemeryWSClientStore) PrivateConns(ctx context.Context, app string, memberID int) ([]Conn, error) {
+	return wcs.privateWSClientsForMember(app, memberID), nil
+}
+
+// Subscriptions implements Store
+func (wcs *InMemeryWSClientStore) Subscriptions(ctx context.Context, connID string) ([]string, error) {
+	return wcs.subscriptions(connID), nil
+}
+
+// Apps implements Store
+func (wcs *InMemeryWSClientStore) Apps(ctx context.Context) ([]string, error) {
+	return wcs.apps(), nil
+}
+
+// AppsConnCount implements StatStore
+func (wcs *InMemeryWSClientStore) AppsConnCount(ctx context.Context) ([]AppConnCount, error) {
+	return wcs.appsWSClientCount(), nil
+}
@@ -17,16 +17,66 @@ func TestWSClientStore(t *testing.T) {
 	assertWSClientCount(t, len(store.publicWSClientsForApp(chatApp)), 0)
 	assertWSClientCount(t, len(store.publicWSClientsForApp(matchApp)), 2)
 
-	assertWSClientCount(t, len(store.privateWSClientsForMember(notConnectedImMemberID)), 0)
-	assertWSClientCount(t, len(store.privateWSClientsForMember(imMemberID)), 1)
+	assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, notConnectedImMemberID)), 0)
+	assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, imMemberID)), 1)
 
 	store.delete(anonymousMemberID, ws1)
 	assertWSClientCount(t, len(store.publicWSClientsForApp(matchApp)), 1)
-	assertWSClientCount(t, len(store.privateWSClientsForMember(imMemberID)), 1)
+	assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, imMemberID)), 1)
 
 	store.delete(imMemberID, ws2)
 	assertWSClientCount(t, len(store.publicWSClientsForApp(matchApp)), 0)
-	assertWSClientCount(t, len(store.privateWSClientsForMember(imMemberID)), 0)
+	assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, imMemberID)), 0)
 	// _, ok := store.appClients[imApp].memberClients[imMemberID]
 	// assertEqual(t, ok, false)
 }
+
+func TestWSClientStoreDeleteForApp(t *testing.T) {
+	chatApp := "chat"
+	matchApp := "match"
+	imMemberID := 123456
+	store := NewInMemeryWSClientStore()
+	ws := newStubWSConn("1")
+	store.save(matchApp, imMemberID, ws)
+	store.save(chatApp, imMemberID, ws)
+	store.save(imApp, imMemberID, ws)
+
+	store.deleteForApp(matchApp, imMemberID, ws)
+	assertWSClientCount(t, len(store.publicWSClientsForApp(matchApp)), 0)
+	assertWSClientCount(t, len(store.publicWSClientsForApp(chatApp)), 1)
+	assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, imMemberID)), 1)
+
+	store.deleteForApp(imApp, imMemberID, ws)
+	assertWSClientCount(t, len(store.publicWSClientsForApp(chatApp)), 1)
+	assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, imMemberID)), 0)
+}
+
+func TestWSClientStoreSameRemoteAddr(t *testing.T) {
+	store := NewInMemeryWSClientStore()
+	ws1 := newStubWSConn("10.0.0.1:80")
+	ws2 := newStubWSConn("10.0.0.1:80")
+	store.save("match", anonymousMemberID, ws1)
+	store.save("match", anonymousMemberID, ws2)
+	assertWSClientCount(t, len(store.publicWSClientsForApp("match")), 2)
+
+	store.delete(anonymousMemberID, ws1)
+	assertEqual(t, store.publicWSClientsForApp("match"), []Conn{ws2})
+}
+
+func TestWSClientStoreSubscriptions(t *testing.T) {
+	imMemberID := 123456
+	store := NewInMemeryWSClientStore()
+	ws := newStubWSConn("1")
+	store.save("match", imMemberID, ws)
+	store.save("chat", imMemberID, ws)
+	store.save(imApp, imMemberID, ws)
+	assertEqual(t, store.subscriptions(ws.ID()), []string{"chat", imApp, "match"})
+
+	store.deleteForApp("chat", imMemberID, ws)
+	assertEqual(t, store.subscriptions(ws.ID()), []string{imApp, "match"})
+
+	store.delete(imMemberID, ws)
+	assertEqual(t, len(store.subscriptions(ws.ID())), 0)
+	assertWSClientCount(t, len(store.publicWSClientsForApp("match")), 0)
+	assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, imMemberID)), 0)
+}
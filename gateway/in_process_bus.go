@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+type inProcessSubscription struct {
+	app      string
+	memberID int
+	handler  func(*PushMessage) error
+}
+
+func (s *inProcessSubscription) matches(pushMsg *PushMessage) bool {
+	return (s.app == "" || s.app == pushMsg.App) &&
+		(s.memberID == AllMembers || s.memberID == pushMsg.MemberID)
+}
+
+// InProcessBus MessageBus delivering messages to subscribers in process,
+// handlers are called by publisher and Publish returns the first handler
+// error, so push queue of server rejects publisher when it is full
+type InProcessBus struct {
+	mu            sync.RWMutex
+	subscriptions map[*inProcessSubscription]struct{}
+}
+
+// NewInProcessBus create a new InProcessBus
+func NewInProcessBus() *InProcessBus {
+	return &InProcessBus{
+		subscriptions: make(map[*inProcessSubscription]struct{}),
+	}
+}
+
+// Publish deliver a copy of push message to each matching subscriber, it is
+// fanned out once all subscribers are done. Handlers before a failing one
+// already got the message, so publishers retrying on error deliver it to
+// them again
+func (b *InProcessBus) Publish(ctx context.Context, pushMsg *PushMessage) error {
+	b.mu.RLock()
+	var handlers []func(*PushMessage) error
+	for sub := range b.subscriptions {
+		if sub.matches(pushMsg) {
+			handlers = append(handlers, sub.handler)
+		}
+	}
+	b.mu.RUnlock()
+
+	done := subscribersDone(pushMsg.done, len(handlers))
+	var result error
+	for _, handler := range handlers {
+		msg := *pushMsg
+		msg.done = done
+		if err := handler(&msg); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// subscribersDone returns func calling done after it is called by all
+// subscribers, done is called right away when there is no subscriber
+func subscribersDone(done func(), subscribers int) func() {
+	if done == nil {
+		return nil
+	}
+	if subscribers == 0 {
+		done()
+		return nil
+	}
+	remaining := int32(subscribers)
+	return func() {
+		if atomic.AddInt32(&remaining, -1) == 0 {
+			done()
+		}
+	}
+}
+
+// Subscribe deliver messages published to app and member to handler until ctx is done
+func (b *InProcessBus) Subscribe(ctx context.Context, app string, memberID int, handler func(*PushMessage) error) error {
+	sub := &inProcessSubscription{app: app, memberID: memberID, handler: handler}
+	b.mu.Lock()
+	b.subscriptions[sub] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		delete(b.subscriptions, sub)
+		b.mu.Unlock()
+	}()
+	return nil
+}
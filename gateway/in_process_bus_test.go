@@ -0,0 +1,158 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// busRecorder records messages delivered to a subscription
+type busRecorder struct {
+	messages chan *PushMessage
+}
+
+func newBusRecorder() *busRecorder {
+	return &busRecorder{messages: make(chan *PushMessage, 16)}
+}
+
+func (r *busRecorder) handle(pushMsg *PushMessage) error {
+	r.messages <- pushMsg
+	return nil
+}
+
+func (r *busRecorder) texts(wait time.Duration) []string {
+	var result []string
+	timeout := time.After(wait)
+	for {
+		select {
+		case pushMsg := <-r.messages:
+			result = append(result, pushMsg.Text)
+		case <-timeout:
+			return result
+		}
+	}
+}
+
+// assertBusSubscriptions checks app and member filters of bus subscriptions
+func assertBusSubscriptions(t *testing.T, bus MessageBus) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	all, match, member := newBusRecorder(), newBusRecorder(), newBusRecorder()
+	assertNoError(t, bus.Subscribe(ctx, "", AllMembers, all.handle))
+	assertNoError(t, bus.Subscribe(ctx, "match", AllMembers, match.handle))
+	assertNoError(t, bus.Subscribe(ctx, imApp, 123, member.handle))
+
+	assertNoError(t, bus.Publish(ctx, &PushMessage{App: "match", MemberID: -1, Text: "score"}))
+	assertNoError(t, bus.Publish(ctx, &PushMessage{App: imApp, MemberID: 123, Text: "hi 123"}))
+	assertNoError(t, bus.Publish(ctx, &PushMessage{App: imApp, MemberID: 456, Text: "hi 456"}))
+
+	assertEqual(t, all.texts(time.Millisecond*50), []string{"score", "hi 123", "hi 456"})
+	assertEqual(t, match.texts(time.Millisecond*10), []string{"score"})
+	assertEqual(t, member.texts(time.Millisecond*10), []string{"hi 123"})
+}
+
+func TestInProcessBus(t *testing.T) {
+	t.Run("subscriptions", func(t *testing.T) {
+		assertBusSubscriptions(t, NewInProcessBus())
+	})
+
+	t.Run("publish returns handler error", func(t *testing.T) {
+		bus := NewInProcessBus()
+		errFull := errors.New("full")
+		assertNoError(t, bus.Subscribe(context.Background(), "", AllMembers, func(*PushMessage) error {
+			return errFull
+		}))
+		assertEqual(t, bus.Publish(context.Background(), &PushMessage{App: "match"}), errFull)
+	})
+
+	t.Run("each subscriber gets its own copy", func(t *testing.T) {
+		bus := NewInProcessBus()
+		first, second := newBusRecorder(), newBusRecorder()
+		assertNoError(t, bus.Subscribe(context.Background(), "", AllMembers, first.handle))
+		assertNoError(t, bus.Subscribe(context.Background(), "", AllMembers, second.handle))
+
+		finished := 0
+		pushMsg := &PushMessage{App: "match", Text: "score"}
+		pushMsg.done = func() {
+			finished++
+		}
+		assertNoError(t, bus.Publish(context.Background(), pushMsg))
+
+		a, b := <-first.messages, <-second.messages
+		assertEqual(t, a != pushMsg && b != pushMsg && a != b, true)
+		a.finish()
+		assertEqual(t, finished, 0)
+		b.finish()
+		assertEqual(t, finished, 1)
+	})
+
+	t.Run("done without subscribers", func(t *testing.T) {
+		finished := false
+		pushMsg := &PushMessage{App: "match", Text: "score"}
+		pushMsg.done = func() {
+			finished = true
+		}
+		assertNoError(t, NewInProcessBus().Publish(context.Background(), pushMsg))
+		assertEqual(t, finished, true)
+	})
+
+	t.Run("unsubscribe when ctx is done", func(t *testing.T) {
+		bus := NewInProcessBus()
+		ctx, cancel := context.WithCancel(context.Background())
+		recorder := newBusRecorder()
+		assertNoError(t, bus.Subscribe(ctx, "", AllMembers, recorder.handle))
+		cancel()
+		time.Sleep(time.Millisecond * 10)
+
+		assertNoError(t, bus.Publish(context.Background(), &PushMessage{App: "match", Text: "late"}))
+		assertEqual(t, len(recorder.texts(time.Millisecond*10)), 0)
+	})
+}
+
+func TestServerPublish(t *testing.T) {
+	gateway := NewGatewayServer(NewInMemeryWSClientStore(), &FakeAuthServer{})
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	ws := mustConnectAndAuthAndSubscribe(t, server, 123456, "654321", "match")
+	defer ws.Close()
+
+	assertNoError(t, gateway.Publish(context.Background(), &PushMessage{App: "match", MemberID: -1, Text: "score"}))
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*100), `{"app":"match","member_id":-1,"text":"score"}`)
+
+	assertNoError(t, gateway.Shutdown(context.Background()))
+	assertEqual(t, gateway.Publish(context.Background(), &PushMessage{App: "match", MemberID: -1, Text: "late"}), ErrServerShutdown)
+}
+
+func TestServersSharingInProcessBus(t *testing.T) {
+	historyApp := "shared-bus-history"
+	registry := NewAppRegistry(AppConfig{Name: historyApp, Visibility: PublicApp, HistorySize: 10})
+
+	bus := NewInProcessBus()
+	var conns []*websocket.Conn
+	for i := 0; i < 2; i++ {
+		gateway := NewGatewayServer(NewInMemeryWSClientStoreForApps(registry), &FakeAuthServer{}, WithMessageBus(bus), WithAppRegistry(registry))
+		server := httptest.NewServer(gateway)
+		defer server.Close()
+		ws := mustConnectAndAuthAndSubscribe(t, server, 123456, "654321", historyApp)
+		defer ws.Close()
+		conns = append(conns, ws)
+	}
+
+	publisher := NewGatewayServer(NewInMemeryWSClientStoreForApps(registry), &FakeAuthServer{}, WithMessageBus(bus), WithAppRegistry(registry))
+	for i := 1; i <= 3; i++ {
+		assertNoError(t, publisher.publishAndWait(context.Background(), &PushMessage{App: historyApp, MemberID: -1, Text: fmt.Sprint(i)}))
+	}
+	for _, ws := range conns {
+		for i := 1; i <= 3; i++ {
+			want := fmt.Sprintf(`{"app":"%s","member_id":-1,"text":"%d","seq":%d}`, historyApp, i, i)
+			assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*100), want)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package gateway
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	jwtAlgHS256 = "HS256"
+	jwtAlgRS256 = "RS256"
+	jwtAlgES256 = "ES256"
+
+	defaultJWTAppsClaim = "apps"
+)
+
+// JWTAuthServerConfig configure JWTAuthServer
+type JWTAuthServerConfig struct {
+	// HMACSecretFile file contains HS256 secret
+	HMACSecretFile string
+	// RSAPublicKeyFile PEM file contains RS256 public key
+	RSAPublicKeyFile string
+	// ECDSAPublicKeyFile PEM file contains ES256 public key
+	ECDSAPublicKeyFile string
+	// JWKSFile JSON web key set file
+	JWKSFile string
+	// Audience required aud claim, empty means not checked
+	Audience string
+	// Issuer required iss claim, empty means not checked
+	Issuer string
+	// Leeway allowed clock skew when checking exp and nbf
+	Leeway time.Duration
+	// AppsClaim claim name of apps member can subscribe
+	AppsClaim string
+}
+
+type jwtKey struct {
+	id  string
+	alg string
+	key interface{}
+}
+
+// JWTAuthServer implements AuthServer by verifying JWT token locally
+type JWTAuthServer struct {
+	config JWTAuthServerConfig
+	keys   []jwtKey
+	now    func() time.Time
+}
+
+// NewJWTAuthServer create a new JWTAuthServer
+func NewJWTAuthServer(config JWTAuthServerConfig) (*JWTAuthServer, error) {
+	if config.AppsClaim == "" {
+		config.AppsClaim = defaultJWTAppsClaim
+	}
+
+	s := &JWTAuthServer{
+		config: config,
+		now:    time.Now,
+	}
+	if err := s.loadKeys(); err != nil {
+		return nil, err
+	}
+	if len(s.keys) == 0 {
+		return nil, errors.New("no jwt key configured")
+	}
+	return s, nil
+}
+
+func (s *JWTAuthServer) loadKeys() error {
+	if s.config.HMACSecretFile != "" {
+		secret, err := ioutil.ReadFile(s.config.HMACSecretFile)
+		if err != nil {
+			return fmt.Errorf("read hmac secret file failed: %v", err)
+		}
+		s.keys = append(s.keys, jwtKey{alg: jwtAlgHS256, key: []byte(strings.TrimSpace(string(secret)))})
+	}
+
+	if s.config.RSAPublicKeyFile != "" {
+		key, err := loadPEMPublicKey(s.config.RSAPublicKeyFile)
+		if err != nil {
+			return err
+		}
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s is not a rsa public key", s.config.RSAPublicKeyFile)
+		}
+		s.keys = append(s.keys, jwtKey{alg: jwtAlgRS256, key: rsaKey})
+	}
+
+	if s.config.ECDSAPublicKeyFile != "" {
+		key, err := loadPEMPublicKey(s.config.ECDSAPublicKeyFile)
+		if err != nil {
+			return err
+		}
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || ecKey.Curve != elliptic.P256() {
+			return fmt.Errorf("%s is not a P-256 ecdsa public key", s.config.ECDSAPublicKeyFile)
+		}
+		s.keys = append(s.keys, jwtKey{alg: jwtAlgES256, key: ecKey})
+	}
+
+	if s.config.JWKSFile != "" {
+		keys, err := loadJWKS(s.config.JWKSFile)
+		if err != nil {
+			return err
+		}
+		s.keys = append(s.keys, keys...)
+	}
+	return nil
+}
+
+func loadPEMPublicKey(path string) (interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read public key file failed: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse public key %s failed: %v", path, err)
+	}
+	return key, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func loadJWKS(path string) ([]jwtKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read jwks file failed: %v", err)
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("parse jwks file failed: %v", err)
+	}
+
+	var keys []jwtKey
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.jwtKey()
+		if err != nil {
+			return nil, fmt.Errorf("parse jwk %q failed: %v", jwk.Kid, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (jwk jsonWebKey) jwtKey() (jwtKey, error) {
+	switch jwk.Kty {
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(jwk.K)
+		if err != nil {
+			return jwtKey{}, err
+		}
+		return jwtKey{id: jwk.Kid, alg: jwtAlgHS256, key: secret}, nil
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return jwtKey{}, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return jwtKey{}, err
+		}
+		key := &rsa.PublicKey{N: n, E: int(e.Int64())}
+		return jwtKey{id: jwk.Kid, alg: jwtAlgRS256, key: key}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return jwtKey{}, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return jwtKey{}, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return jwtKey{}, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		return jwtKey{id: jwk.Kid, alg: jwtAlgES256, key: key}, nil
+	}
+	return jwtKey{}, fmt.Errorf("unsupported key type %s", jwk.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims map[string]interface{}
+
+// Auth check if member token is a valid JWT
+func (s *JWTAuthServer) Auth(memberID int, token string) bool {
+	_, err := s.Authenticate(context.Background(), AuthMessage{MemberID: memberID, Token: token})
+	return err == nil
+}
+
+// Authenticate check if member token is a valid JWT, identity apps are
+// loaded from apps claim
+func (s *JWTAuthServer) Authenticate(ctx context.Context, auth AuthMessage) (Identity, error) {
+	claims, err := s.verify(auth.Token)
+	if err != nil {
+		log.Printf("verify jwt of member %d failed: %v", auth.MemberID, err)
+		return Identity{}, ErrUnauthorized
+	}
+	if err := s.validateClaims(claims, auth.MemberID); err != nil {
+		log.Printf("validate jwt claims of member %d failed: %v", auth.MemberID, err)
+		return Identity{}, ErrUnauthorized
+	}
+	apps, err := claims.stringSlice(s.config.AppsClaim)
+	if err != nil {
+		log.Printf("validate jwt claims of member %d failed: %v", auth.MemberID, err)
+		return Identity{}, ErrUnauthorized
+	}
+	expiresAt, _, _ := claims.time("exp")
+	return Identity{MemberID: auth.MemberID, Apps: apps, ExpiresAt: expiresAt}, nil
+}
+
+func (s *JWTAuthServer) verify(token string) (jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("decode header failed: %v", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decode signature failed: %v", err)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range s.keys {
+		if key.alg != header.Alg {
+			continue
+		}
+		if header.Kid != "" && key.id != "" && header.Kid != key.id {
+			continue
+		}
+		if verifyJWTSignature(key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("invalid %s signature", header.Alg)
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("decode claims failed: %v", err)
+	}
+	return claims, nil
+}
+
+func decodeJWTSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func verifyJWTSignature(key jwtKey, signed, signature []byte) bool {
+	switch key.alg {
+	case jwtAlgHS256:
+		mac := hmac.New(sha256.New, key.key.([]byte))
+		mac.Write(signed)
+		return hmac.Equal(signature, mac.Sum(nil))
+	case jwtAlgRS256:
+		hashed := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(key.key.(*rsa.PublicKey), crypto.SHA256, hashed[:], signature) == nil
+	case jwtAlgES256:
+		if len(signature) != 64 {
+			return false
+		}
+		hashed := sha256.Sum256(signed)
+		r := new(big.Int).SetBytes(signature[:32])
+		ss := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key.key.(*ecdsa.PublicKey), hashed[:], r, ss)
+	}
+	return false
+}
+
+func (s *JWTAuthServer) validateClaims(claims jwtClaims, memberID int) error {
+	now := s.now()
+
+	exp, ok, err := claims.time("exp")
+	if err != nil {
+		return err
+	}
+	if ok && !now.Before(exp.Add(s.config.Leeway)) {
+		return errors.New("token expired")
+	}
+
+	nbf, ok, err := claims.time("nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(s.config.Leeway).Before(nbf) {
+		return errors.New("token not valid yet")
+	}
+
+	if s.config.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != s.config.Issuer {
+			return fmt.Errorf("unexpected issuer %q", iss)
+		}
+	}
+
+	if s.config.Audience != "" {
+		auds, err := claims.stringSlice("aud")
+		if err != nil {
+			return err
+		}
+		if !containsString(auds, s.config.Audience) {
+			return fmt.Errorf("unexpected audience %v", auds)
+		}
+	}
+
+	sub := fmt.Sprint(claims["sub"])
+	if sub != strconv.Itoa(memberID) {
+		return fmt.Errorf("subject %q does not match member id", sub)
+	}
+	return nil
+}
+
+func (c jwtClaims) time(name string) (time.Time, bool, error) {
+	v, ok := c[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("claim %s is not a number", name)
+	}
+	seconds, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("claim %s is not a number", name)
+	}
+	return time.Unix(int64(seconds), 0), true, nil
+}
+
+func (c jwtClaims) stringSlice(name string) ([]string, error) {
+	v, ok := c[name]
+	if !ok {
+		return nil, nil
+	}
+	switch v := v.(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("claim %s is not a string array", name)
+			}
+			result = append(result, s)
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("claim %s is not a string array", name)
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package gateway
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJWTAuthServer(t *testing.T) {
+	dir := mustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	hmacSecret := []byte("jwt-secret")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	authServer, err := NewJWTAuthServer(JWTAuthServerConfig{
+		HMACSecretFile:     mustWriteFile(t, dir, "secret", hmacSecret),
+		RSAPublicKeyFile:   mustWritePublicKeyPEM(t, dir, "rsa.pem", &rsaKey.PublicKey),
+		ECDSAPublicKeyFile: mustWritePublicKeyPEM(t, dir, "ec.pem", &ecKey.PublicKey),
+		Audience:           "ws-gateway",
+		Issuer:             "member-service",
+	})
+	assertNoError(t, err)
+
+	now := time.Now().Unix()
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"sub": "123456",
+			"iss": "member-service",
+			"aud": []string{"ws-gateway", "api"},
+			"exp": now + 60,
+			"nbf": now - 60,
+		}
+	}
+	withClaim := func(name string, value interface{}) map[string]interface{} {
+		claims := validClaims()
+		claims[name] = value
+		return claims
+	}
+
+	otherRSAKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	tests := []struct {
+		description string
+		token       string
+		want        bool
+	}{
+		{"HS256 token", signJWT(t, jwtAlgHS256, "", hmacSecret, validClaims()), true},
+		{"RS256 token", signJWT(t, jwtAlgRS256, "", rsaKey, validClaims()), true},
+		{"ES256 token", signJWT(t, jwtAlgES256, "", ecKey, validClaims()), true},
+		{"RS256 token signed by unknown key", signJWT(t, jwtAlgRS256, "", otherRSAKey, validClaims()), false},
+		{"HS256 token signed by wrong secret", signJWT(t, jwtAlgHS256, "", []byte("wrong"), validClaims()), false},
+		{"expired token", signJWT(t, jwtAlgHS256, "", hmacSecret, withClaim("exp", now-1)), false},
+		{"not valid yet token", signJWT(t, jwtAlgHS256, "", hmacSecret, withClaim("nbf", now+60)), false},
+		{"wrong audience", signJWT(t, jwtAlgHS256, "", hmacSecret, withClaim("aud", "api")), false},
+		{"wrong issuer", signJWT(t, jwtAlgHS256, "", hmacSecret, withClaim("iss", "other")), false},
+		{"wrong subject", signJWT(t, jwtAlgHS256, "", hmacSecret, withClaim("sub", "12345")), false},
+		{"malformed token", "not-a-jwt", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			assertEqual(t, authServer.Auth(123456, tt.token), tt.want)
+		})
+	}
+
+	t.Run("apps claim", func(t *testing.T) {
+		token := signJWT(t, jwtAlgHS256, "", hmacSecret, withClaim("apps", []string{"match"}))
+		identity, err := authServer.Authenticate(context.Background(), AuthMessage{MemberID: 123456, Token: token})
+		assertNoError(t, err)
+		assertEqual(t, identity.Apps, []string{"match"})
+
+		token = signJWT(t, jwtAlgHS256, "", hmacSecret, validClaims())
+		identity, err = authServer.Authenticate(context.Background(), AuthMessage{MemberID: 123456, Token: token})
+		assertNoError(t, err)
+		assertEqual(t, identity.Apps == nil, true)
+	})
+}
+
+func TestJWTAuthServerWithJWKS(t *testing.T) {
+	dir := mustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	encode := func(b []byte) string {
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": encode(rsaKey.N.Bytes()), "e": encode([]byte{1, 0, 1})},
+			{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": encode(ecKey.X.Bytes()), "y": encode(ecKey.Y.Bytes())},
+			{"kty": "oct", "kid": "oct-1", "k": encode([]byte("jwks-secret"))},
+		},
+	}
+	data, _ := json.Marshal(jwks)
+
+	authServer, err := NewJWTAuthServer(JWTAuthServerConfig{
+		JWKSFile: mustWriteFile(t, dir, "jwks.json", data),
+	})
+	assertNoError(t, err)
+
+	claims := map[string]interface{}{"sub": 123456, "exp": time.Now().Unix() + 60}
+	assertEqual(t, authServer.Auth(123456, signJWT(t, jwtAlgRS256, "rsa-1", rsaKey, claims)), true)
+	assertEqual(t, authServer.Auth(123456, signJWT(t, jwtAlgES256, "ec-1", ecKey, claims)), true)
+	assertEqual(t, authServer.Auth(123456, signJWT(t, jwtAlgHS256, "oct-1", []byte("jwks-secret"), claims)), true)
+	assertEqual(t, authServer.Auth(123456, signJWT(t, jwtAlgRS256, "ec-1", rsaKey, claims)), false)
+}
+
+func TestJWTAuthServerLimitSubscribeApps(t *testing.T) {
+	dir := mustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	hmacSecret := []byte("jwt-secret")
+	authServer, err := NewJWTAuthServer(JWTAuthServerConfig{
+		HMACSecretFile: mustWriteFile(t, dir, "secret", hmacSecret),
+	})
+	assertNoError(t, err)
+
+	store := NewInMemeryWSClientStore()
+	server := httptest.NewServer(NewGatewayServer(store, authServer))
+	defer server.Close()
+
+	memberID := 123456
+	token := signJWT(t, jwtAlgHS256, "", hmacSecret, map[string]interface{}{
+		"sub":  fmt.Sprint(memberID),
+		"apps": []string{"match", imApp},
+	})
+
+	ws, _ := mustConnectTo(t, server)
+	defer ws.Close()
+	mustSendAuthMessage(t, ws, memberID, token)
+	assertHelloMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10), helloMessageForMember(memberID))
+
+	mustSendSubscribeMessage(t, ws, "match")
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10), subscribeSuccessMessageForApp("match"))
+
+	mustSendSubscribeMessage(t, ws, imApp)
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10), subscribeSuccessMessageForApp(imApp))
+
+	mustSendSubscribeMessage(t, ws, "chat")
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10), subscribeForbiddenMessageForApp("chat"))
+}
+
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	headerJSON, _ := json.Marshal(header)
+	claimsJSON, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	hashed := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch alg {
+	case jwtAlgHS256:
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case jwtAlgRS256:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, hashed[:])
+		assertNoError(t, err)
+	case jwtAlgES256:
+		r, s, err := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), hashed[:])
+		assertNoError(t, err)
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func mustTempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "ws-gateway")
+	if err != nil {
+		t.Fatalf("create temp dir failed %v", err)
+	}
+	return dir
+}
+
+func mustWriteFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("write file failed %v", err)
+	}
+	return path
+}
+
+func mustWritePublicKeyPEM(t *testing.T, dir, name string, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatalf("marshal public key failed %v", err)
+	}
+	return mustWriteFile(t, dir, name, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
@@ -0,0 +1,158 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const defaultKafkaRetryDelay = time.Second
+
+// KafkaRecord record consumed from kafka topic
+type KafkaRecord struct {
+	Topic     string
+	Partition int
+	Offset    int64
+	Key       []byte
+	Value     []byte
+}
+
+// KafkaReader reader of a topic in a consumer group
+type KafkaReader interface {
+	// FetchRecord returns next record, it blocks until a record is
+	// available or ctx is done
+	FetchRecord(ctx context.Context) (KafkaRecord, error)
+	CommitRecords(ctx context.Context, records ...KafkaRecord) error
+	Close() error
+}
+
+// KafkaConsumerConfig config of KafkaConsumer
+type KafkaConsumerConfig struct {
+	// Topics maps kafka topics to apps
+	Topics map[string]string
+	// NewReader create reader of topic in consumer group
+	NewReader func(topic string) (KafkaReader, error)
+	// Decode decode record of app into push message, DecodeKafkaRecord is used when nil
+	Decode func(app string, record KafkaRecord) (*PushMessage, error)
+	// RetryDelay wait before publishing record again when push is rejected,
+	// subscribers of bus that accepted the rejected push receive it again
+	RetryDelay time.Duration
+}
+
+// DecodeKafkaRecord decode record value as push message of app
+func DecodeKafkaRecord(app string, record KafkaRecord) (*PushMessage, error) {
+	var pushMsg PushMessage
+	if err := json.Unmarshal(record.Value, &pushMsg); err != nil {
+		return nil, err
+	}
+	pushMsg.App = app
+	return &pushMsg, nil
+}
+
+// KafkaConsumer consume kafka topics as push source, offset of a record is
+// committed only after the record is fanned out to local connections, or
+// published when server uses a bus shared by nodes
+type KafkaConsumer struct {
+	server *Server
+	config KafkaConsumerConfig
+}
+
+// NewKafkaConsumer create a new KafkaConsumer feeding push pipeline of server
+func NewKafkaConsumer(server *Server, config KafkaConsumerConfig) *KafkaConsumer {
+	if config.Decode == nil {
+		config.Decode = DecodeKafkaRecord
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = defaultKafkaRetryDelay
+	}
+	return &KafkaConsumer{
+		server: server,
+		config: config,
+	}
+}
+
+// Run consume all topics until ctx is done or server shuts down, records
+// not committed are consumed again by the consumer group later
+func (c *KafkaConsumer) Run(ctx context.Context) error {
+	readers := make(map[string]KafkaReader, len(c.config.Topics))
+	for topic := range c.config.Topics {
+		reader, err := c.config.NewReader(topic)
+		if err != nil {
+			for _, r := range readers {
+				r.Close()
+			}
+			return fmt.Errorf("create kafka reader of topic %s failed: %v", topic, err)
+		}
+		readers[topic] = reader
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(readers))
+	for topic, reader := range readers {
+		wg.Add(1)
+		go func(app string, reader KafkaReader) {
+			defer wg.Done()
+			defer reader.Close()
+			if err := c.consume(ctx, app, reader); err != nil {
+				errs <- err
+				cancel()
+			}
+		}(c.config.Topics[topic], reader)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+func (c *KafkaConsumer) consume(ctx context.Context, app string, reader KafkaReader) error {
+	for {
+		record, err := reader.FetchRecord(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("fetch kafka record failed: %v", err)
+		}
+
+		pushMsg, err := c.config.Decode(app, record)
+		if err != nil {
+			log.Printf("drop bad kafka record %s/%d/%d: %v", record.Topic, record.Partition, record.Offset, err)
+		} else if err := c.publish(ctx, pushMsg); err != nil {
+			if err == ErrServerShutdown || ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if err := reader.CommitRecords(ctx, record); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("commit kafka record failed: %v", err)
+		}
+	}
+}
+
+// publish publish push message until it is fanned out, rejected pushes are
+// retried after retry delay
+func (c *KafkaConsumer) publish(ctx context.Context, pushMsg *PushMessage) error {
+	for {
+		err := c.server.publishAndWait(ctx, pushMsg)
+		if err == nil || err == ErrServerShutdown || ctx.Err() != nil {
+			return err
+		}
+
+		log.Printf("publish kafka push message of app %s failed, retry in %v: %v", pushMsg.App, c.config.RetryDelay, err)
+		select {
+		case <-time.After(c.config.RetryDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
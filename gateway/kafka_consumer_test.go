@@ -0,0 +1,116 @@
+package gateway
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newFakeKafkaConsumer(server *Server, broker *FakeKafkaBroker, topics map[string]string) *KafkaConsumer {
+	return NewKafkaConsumer(server, KafkaConsumerConfig{
+		Topics: topics,
+		NewReader: func(topic string) (KafkaReader, error) {
+			return broker.NewReader("gateway", topic), nil
+		},
+		RetryDelay: time.Millisecond * 10,
+	})
+}
+
+func runKafkaConsumer(consumer *KafkaConsumer) (cancel func(), stopped chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped = make(chan error, 1)
+	go func() {
+		stopped <- consumer.Run(ctx)
+	}()
+	return cancel, stopped
+}
+
+func assertCommitted(t *testing.T, broker *FakeKafkaBroker, topic string, want int64) {
+	t.Helper()
+	deadline := time.Now().Add(time.Millisecond * 200)
+	for broker.Committed("gateway", topic) != want && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 5)
+	}
+	assertEqual(t, broker.Committed("gateway", topic), want)
+}
+
+func TestKafkaConsumer(t *testing.T) {
+	store := NewInMemeryWSClientStore()
+	gateway := NewGatewayServer(store, &FakeAuthServer{})
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	ws := mustConnectAndAuthAndSubscribe(t, server, 123456, "654321", "match")
+	defer ws.Close()
+	mustSendSubscribeMessage(t, ws, imApp)
+	mustReadMessageWithTimeout(t, ws, time.Millisecond*10)
+
+	broker := NewFakeKafkaBroker()
+	cancel, stopped := runKafkaConsumer(newFakeKafkaConsumer(gateway, broker, map[string]string{
+		"score-events": "match",
+		"chat-events":  imApp,
+	}))
+
+	broker.Produce("score-events", nil, []byte(`{"member_id":-1,"text":"1:0"}`))
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*100), `{"app":"match","member_id":-1,"text":"1:0"}`)
+	assertCommitted(t, broker, "score-events", 1)
+
+	broker.Produce("chat-events", nil, []byte(`{"app":"ignored","member_id":123456,"text":"hi"}`))
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*100), `{"app":"im","member_id":123456,"text":"hi"}`)
+	assertCommitted(t, broker, "chat-events", 1)
+
+	broker.Produce("score-events", nil, []byte(`not json`))
+	assertCommitted(t, broker, "score-events", 2)
+
+	cancel()
+	assertNoError(t, <-stopped)
+}
+
+func TestKafkaConsumerCommitsAfterFanout(t *testing.T) {
+	store := newGatedWSStore()
+	gateway := NewGatewayServer(store, &FakeAuthServer{})
+
+	broker := NewFakeKafkaBroker()
+	cancel, stopped := runKafkaConsumer(newFakeKafkaConsumer(gateway, broker, map[string]string{"score-events": "match"}))
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	broker.Produce("score-events", nil, []byte(`{"member_id":-1,"text":"1:0"}`))
+	time.Sleep(time.Millisecond * 50)
+	assertEqual(t, broker.Committed("gateway", "score-events"), int64(0))
+
+	close(store.gate)
+	assertCommitted(t, broker, "score-events", 1)
+}
+
+func TestKafkaConsumerGroupResumes(t *testing.T) {
+	broker := NewFakeKafkaBroker()
+	topics := map[string]string{"score-events": "match"}
+
+	gateway := NewGatewayServer(NewInMemeryWSClientStore(), &FakeAuthServer{})
+	_, stopped := runKafkaConsumer(newFakeKafkaConsumer(gateway, broker, topics))
+	broker.Produce("score-events", nil, []byte(`{"member_id":-1,"text":"1:0"}`))
+	assertCommitted(t, broker, "score-events", 1)
+
+	assertNoError(t, gateway.Shutdown(context.Background()))
+	broker.Produce("score-events", nil, []byte(`{"member_id":-1,"text":"2:0"}`))
+	assertNoError(t, <-stopped)
+	assertEqual(t, broker.Committed("gateway", "score-events"), int64(1))
+
+	gateway = NewGatewayServer(NewInMemeryWSClientStore(), &FakeAuthServer{})
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+	ws := mustConnectAndAuthAndSubscribe(t, server, 123456, "654321", "match")
+	defer ws.Close()
+
+	cancel, stopped := runKafkaConsumer(newFakeKafkaConsumer(gateway, broker, topics))
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*100), `{"app":"match","member_id":-1,"text":"2:0"}`)
+	assertCommitted(t, broker, "score-events", 2)
+}
@@ -0,0 +1,53 @@
+package gateway
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaGoReader KafkaReader based on kafka-go consumer group reader
+type kafkaGoReader struct {
+	reader *kafka.Reader
+}
+
+// NewKafkaGoReader create a KafkaReader of topic in consumer group groupID
+func NewKafkaGoReader(brokers []string, groupID string, topic string) KafkaReader {
+	return &kafkaGoReader{
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers: brokers,
+			GroupID: groupID,
+			Topic:   topic,
+		}),
+	}
+}
+
+func (r *kafkaGoReader) FetchRecord(ctx context.Context) (KafkaRecord, error) {
+	msg, err := r.reader.FetchMessage(ctx)
+	if err != nil {
+		return KafkaRecord{}, err
+	}
+	return KafkaRecord{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       msg.Key,
+		Value:     msg.Value,
+	}, nil
+}
+
+func (r *kafkaGoReader) CommitRecords(ctx context.Context, records ...KafkaRecord) error {
+	msgs := make([]kafka.Message, 0, len(records))
+	for _, record := range records {
+		msgs = append(msgs, kafka.Message{
+			Topic:     record.Topic,
+			Partition: record.Partition,
+			Offset:    record.Offset,
+		})
+	}
+	return r.reader.CommitMessages(ctx, msgs...)
+}
+
+func (r *kafkaGoReader) Close() error {
+	return r.reader.Close()
+}
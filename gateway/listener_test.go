@@ -0,0 +1,56 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSeparateHandlers(t *testing.T) {
+	store := NewInMemeryWSClientStore()
+	gateway := NewGatewayServer(store, &FakeAuthServer{})
+	wsServer := httptest.NewServer(gateway.WebSocketHandler())
+	defer wsServer.Close()
+	pushServer := httptest.NewServer(gateway.PushHandler())
+	defer pushServer.Close()
+
+	app := "match"
+	ws := mustConnectAndAuthAndSubscribe(t, wsServer, anonymousMemberID, "", app)
+	defer ws.Close()
+
+	t.Run("push api is not served on websocket listener", func(t *testing.T) {
+		response, err := pushMessage(wsServer.URL+pushURLPath, app, anonymousMemberID, `{"hello":"world"}`)
+		assertNoError(t, err)
+		assertStatusCode(t, response.StatusCode, http.StatusBadRequest)
+		_, err = readMessageWithTimeout(ws, time.Millisecond*10)
+		assertError(t, err)
+	})
+
+	t.Run("websocket is not served on push listener", func(t *testing.T) {
+		response, err := http.Get(pushServer.URL + websocketURLPath)
+		assertNoError(t, err)
+		assertStatusCode(t, response.StatusCode, http.StatusNotFound)
+	})
+}
+
+func TestPushOnPushListener(t *testing.T) {
+	store := NewInMemeryWSClientStore()
+	gateway := NewGatewayServer(store, &FakeAuthServer{})
+	wsServer := httptest.NewServer(gateway.WebSocketHandler())
+	defer wsServer.Close()
+	pushServer := httptest.NewServer(gateway.PushHandler())
+	defer pushServer.Close()
+
+	app := "match"
+	pushText := `{"hello":"world"}`
+	ws := mustConnectAndAuthAndSubscribe(t, wsServer, anonymousMemberID, "", app)
+	defer ws.Close()
+
+	response, err := pushMessage(pushServer.URL+pushURLPath, app, anonymousMemberID, pushText)
+	assertNoError(t, err)
+	assertStatusCode(t, response.StatusCode, http.StatusAccepted)
+
+	msg := mustReadMessageWithTimeout(t, ws, time.Millisecond*10)
+	assertMessage(t, msg, string(pushMessageJSONFor(app, anonymousMemberID, pushText)))
+}
@@ -0,0 +1,165 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// AllMembers member id of subscription matching messages of all members
+const AllMembers = 0
+
+const publishFailedMessage = "publish push message failed, retry later"
+
+// ErrPushQueueFull returned by Publish when push queue of server is full
+var ErrPushQueueFull = errors.New(pushQueueOverloadMessage)
+
+// MessageBus carries push messages to fan-out, server subscribes messages
+// of all apps and queues them for delivery to its local connections.
+// Buses shared by gateway nodes deliver messages published by any node to
+// every node.
+type MessageBus interface {
+	Publish(ctx context.Context, pushMsg *PushMessage) error
+	// Subscribe deliver messages published to app and member to handler
+	// until ctx is done, empty app matches all apps and AllMembers matches
+	// all members, subscription is ready when Subscribe returns
+	Subscribe(ctx context.Context, app string, memberID int, handler func(*PushMessage) error) error
+}
+
+// subscribeBus subscribe messages of all apps from bus
+func (g *Server) subscribeBus() {
+	ctx, cancel := context.WithCancel(context.Background())
+	g.unsubscribeBus = cancel
+	handler := g.receivePush
+	if g.sharedBus() {
+		handler = g.receiveSharedPush
+	}
+	if err := g.bus.Subscribe(ctx, "", AllMembers, handler); err != nil {
+		log.Printf("subscribe message bus failed: %v", err)
+	}
+	if nodeBus, ok := g.nodeBus(); ok {
+		if err := nodeBus.SubscribeNode(ctx, g.node, g.receiveSharedPush); err != nil {
+			log.Printf("subscribe message bus of node %s failed: %v", g.node, err)
+		}
+	}
+}
+
+// sharedBus reports whether message bus is shared by gateway nodes, such
+// buses deliver messages on their own goroutines instead of publisher's
+func (g *Server) sharedBus() bool {
+	_, ok := g.bus.(*InProcessBus)
+	return !ok
+}
+
+// nodeBus returns message bus when private pushes can be routed to nodes
+// holding connections of member
+func (g *Server) nodeBus() (NodeBus, bool) {
+	if g.presence == nil {
+		return nil, false
+	}
+	nodeBus, ok := g.bus.(NodeBus)
+	return nodeBus, ok
+}
+
+// receivePush queue message received from InProcessBus for fan-out, it
+// runs on goroutine of publisher which may wait for queue space
+func (g *Server) receivePush(pushMsg *PushMessage) error {
+	return g.queuePush(pushMsg, true)
+}
+
+// receiveSharedPush queue message received from shared bus for fan-out
+// without waiting, so one full node does not stall subscriber of bus
+func (g *Server) receiveSharedPush(pushMsg *PushMessage) error {
+	return g.queuePush(pushMsg, false)
+}
+
+func (g *Server) queuePush(pushMsg *PushMessage, wait bool) error {
+	queued, shuttingDown := g.enqueuePush(pushMsg, wait)
+	if shuttingDown {
+		return ErrServerShutdown
+	}
+	if !queued {
+		return ErrPushQueueFull
+	}
+	return nil
+}
+
+// Publish publish push message to message bus of server, producers in
+// process can publish without push api
+func (g *Server) Publish(ctx context.Context, pushMsg *PushMessage) error {
+	if g.isShuttingDown() {
+		return ErrServerShutdown
+	}
+	// nodes of shared bus drop messages instead of waiting when queue is
+	// full, reject push while local queue is full so producers retry
+	if g.sharedBus() && g.pushQueue.rejectFull() {
+		return ErrPushQueueFull
+	}
+
+	nodeBus, ok := g.nodeBus()
+	if !ok || !g.apps.isPrivate(pushMsg.App) {
+		return g.bus.Publish(ctx, pushMsg)
+	}
+
+	// private push is published only to nodes holding connections of member
+	nodes, err := g.presence.registry.Nodes(ctx, pushMsg.App, pushMsg.MemberID)
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+	return nodeBus.PublishToNodes(ctx, nodes, pushMsg)
+}
+
+func (g *Server) publishPush(w http.ResponseWriter, r *http.Request, pushMsg *PushMessage) {
+	err := g.Publish(r.Context(), pushMsg)
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusAccepted)
+		return
+	case ErrServerShutdown:
+		g.rejectShuttingDown(w)
+		return
+	case ErrPushQueueFull:
+		log.Println(pushQueueOverloadMessage)
+		w.Header().Set("Retry-After", g.pushQueue.retryAfter())
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, pushQueueOverloadMessage)
+		return
+	}
+
+	log.Printf("publish push message failed: %v", err)
+	w.Header().Set("Retry-After", g.pushQueue.retryAfter())
+	w.WriteHeader(http.StatusServiceUnavailable)
+	fmt.Fprintln(w, publishFailedMessage)
+}
+
+// publishAndWait publish push message and wait until it is fanned out to
+// local connections of every server subscribed to InProcessBus, messages
+// handed to a bus shared by nodes are done once published
+func (g *Server) publishAndWait(ctx context.Context, pushMsg *PushMessage) error {
+	if g.sharedBus() {
+		return g.Publish(ctx, pushMsg)
+	}
+
+	done := make(chan struct{})
+	var once sync.Once
+	pushMsg.done = func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
+	if err := g.Publish(ctx, pushMsg); err != nil {
+		return err
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultNATSBusSubject default subject push messages are published under
+const DefaultNATSBusSubject = "ws-gateway.push"
+
+// NATSBus MessageBus based on NATS, messages are published to subject
+// "<subject>.<app>.<member id>", so app names must not contain '.', '*' or '>'
+type NATSBus struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// NewNATSBus create a new NATSBus publishing push messages under subject
+func NewNATSBus(conn *nats.Conn, subject string) *NATSBus {
+	if subject == "" {
+		subject = DefaultNATSBusSubject
+	}
+	return &NATSBus{
+		conn:    conn,
+		subject: subject,
+	}
+}
+
+// Publish publish push message to subscribers of its app and member on all nodes
+func (b *NATSBus) Publish(ctx context.Context, pushMsg *PushMessage) error {
+	data, err := json.Marshal(pushMsg)
+	if err != nil {
+		return err
+	}
+	return b.conn.Publish(fmt.Sprintf("%s.%s.%d", b.subject, pushMsg.App, pushMsg.MemberID), data)
+}
+
+// PublishToNodes publish push message to given nodes only
+func (b *NATSBus) PublishToNodes(ctx context.Context, nodes []string, pushMsg *PushMessage) error {
+	data, err := json.Marshal(pushMsg)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if err := b.conn.Publish(b.nodeSubject(node), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Subscribe deliver push messages published to app and member to handler until ctx is done
+func (b *NATSBus) Subscribe(ctx context.Context, app string, memberID int, handler func(*PushMessage) error) error {
+	appToken, memberToken := app, strconv.Itoa(memberID)
+	if app == "" {
+		appToken = "*"
+	}
+	if memberID == AllMembers {
+		memberToken = "*"
+	}
+	return b.subscribe(ctx, b.subject+"."+appToken+"."+memberToken, handler)
+}
+
+// SubscribeNode deliver push messages published to node to handler until ctx is done
+func (b *NATSBus) SubscribeNode(ctx context.Context, node string, handler func(*PushMessage) error) error {
+	return b.subscribe(ctx, b.nodeSubject(node), handler)
+}
+
+// nodeSubject subject of node, it is out of "<subject>.>" so subscriptions
+// of all apps do not receive messages of nodes
+func (b *NATSBus) nodeSubject(node string) string {
+	return b.subject + "-node." + node
+}
+
+func (b *NATSBus) subscribe(ctx context.Context, subject string, handler func(*PushMessage) error) error {
+	sub, err := b.conn.Subscribe(subject, func(msg *nats.Msg) {
+		var pushMsg PushMessage
+		if err := json.Unmarshal(msg.Data, &pushMsg); err != nil {
+			log.Printf("drop bad push message %q: %v", msg.Data, err)
+			return
+		}
+		if err := handler(&pushMsg); err != nil {
+			log.Printf("handle push message of app %s failed: %v", pushMsg.App, err)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("subscribe nats subject %s failed: %v", subject, err)
+	}
+	if err := b.conn.Flush(); err != nil {
+		sub.Unsubscribe()
+		return fmt.Errorf("subscribe nats subject %s failed: %v", subject, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		sub.Unsubscribe()
+	}()
+	return nil
+}
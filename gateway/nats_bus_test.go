@@ -0,0 +1,57 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+)
+
+func runNATSServer(t *testing.T) (url string, shutdown func()) {
+	t.Helper()
+	opts := natsserver.DefaultTestOptions
+	opts.Port = -1
+	s := natsserver.RunServer(&opts)
+	return s.ClientURL(), s.Shutdown
+}
+
+func newNATSBus(t *testing.T, url string) *NATSBus {
+	t.Helper()
+	conn, err := nats.Connect(url)
+	assertNoError(t, err)
+	return NewNATSBus(conn, "")
+}
+
+func TestNATSBus(t *testing.T) {
+	url, shutdown := runNATSServer(t)
+	defer shutdown()
+
+	t.Run("subscriptions", func(t *testing.T) {
+		assertBusSubscriptions(t, newNATSBus(t, url))
+	})
+
+	t.Run("push reaches clients on all nodes", func(t *testing.T) {
+		gateway1 := NewGatewayServer(NewInMemeryWSClientStore(), &FakeAuthServer{}, WithMessageBus(newNATSBus(t, url)))
+		gateway2 := NewGatewayServer(NewInMemeryWSClientStore(), &FakeAuthServer{}, WithMessageBus(newNATSBus(t, url)))
+		server1 := httptest.NewServer(gateway1)
+		defer server1.Close()
+		server2 := httptest.NewServer(gateway2)
+		defer server2.Close()
+
+		ws1 := mustConnectAndAuthAndSubscribe(t, server1, 123456, "654321", "match")
+		defer ws1.Close()
+		ws2 := mustConnectAndAuthAndSubscribe(t, server2, 123456, "654321", "match")
+		defer ws2.Close()
+
+		response := httptest.NewRecorder()
+		gateway1.ServeHTTP(response, newPushMessagePostRequest("match", anonymousMemberID, "score"))
+		assertStatusCode(t, response.Code, http.StatusAccepted)
+
+		want := `{"app":"match","member_id":-1,"text":"score"}`
+		assertMessage(t, mustReadMessageWithTimeout(t, ws1, time.Millisecond*100), want)
+		assertMessage(t, mustReadMessageWithTimeout(t, ws2, time.Millisecond*100), want)
+	})
+}
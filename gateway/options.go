@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"strings"
+	"time"
+)
+
+// ServerOption configure Server
+type ServerOption func(*Server)
+
+// WithAuthCookie set cookie name of auth token in websocket upgrade request
+func WithAuthCookie(name string) ServerOption {
+	return func(s *Server) {
+		s.authCookieName = name
+	}
+}
+
+// WithAllowedOrigins allow websocket upgrade requests carrying auth cookie
+// from origins like https://example.com besides the gateway origin
+func WithAllowedOrigins(origins ...string) ServerOption {
+	return func(s *Server) {
+		if s.allowedOrigins == nil {
+			s.allowedOrigins = make(map[string]bool)
+		}
+		for _, origin := range origins {
+			s.allowedOrigins[strings.ToLower(origin)] = true
+		}
+	}
+}
+
+// WithAppRegistry set app definitions of server, servers without it use
+// DefaultAppRegistry, store should be created with the same registry
+func WithAppRegistry(registry *AppRegistry) ServerOption {
+	return func(s *Server) {
+		s.apps = registry
+	}
+}
+
+// WithCloseOnAuthExpired close connection when identity expired,
+// otherwise private subscriptions are dropped and connection becomes anonymous
+func WithCloseOnAuthExpired() ServerOption {
+	return func(s *Server) {
+		s.closeOnAuthExpired = true
+	}
+}
+
+// WithPushAuth require push requests signed by api keys of PushAuth
+func WithPushAuth(auth *PushAuth) ServerOption {
+	return func(s *Server) {
+		s.pushAuth = auth
+	}
+}
+
+// WithHeartbeat send websocket ping every pingInterval and close connection
+// which sends nothing in pongWait, pingInterval 0 disables heartbeat
+func WithHeartbeat(pingInterval, pongWait time.Duration) ServerOption {
+	return func(s *Server) {
+		if pongWait <= pingInterval {
+			pongWait = pingInterval * 2
+		}
+		s.heartbeat = heartbeatConfig{
+			pingInterval: pingInterval,
+			pongWait:     pongWait,
+		}
+	}
+}
+
+// WithSendQueueSize set size of outbound message queue of each connection
+func WithSendQueueSize(size int) ServerOption {
+	return func(s *Server) {
+		if size > 0 {
+			s.sendQueueSize = size
+		}
+	}
+}
+
+// WithWriteTimeout set write deadline of each message written to connection
+func WithWriteTimeout(timeout time.Duration) ServerOption {
+	return func(s *Server) {
+		if timeout > 0 {
+			s.writeWait = timeout
+		}
+	}
+}
+
+// WithSlowConsumerPolicy set policy applied when send queue of connection is full
+func WithSlowConsumerPolicy(policy SlowConsumerPolicy) ServerOption {
+	return func(s *Server) {
+		s.slowConsumerPolicy = policy
+	}
+}
+
+// WithFanoutWorkers set count of workers delivering push messages to connections
+func WithFanoutWorkers(workers int) ServerOption {
+	return func(s *Server) {
+		if workers > 0 {
+			s.fanoutWorkers = workers
+		}
+	}
+}
+
+// WithPushQueue set size of push queue and policy applied when it is full
+func WithPushQueue(config PushQueueConfig) ServerOption {
+	return func(s *Server) {
+		s.pushQueueConfig = config
+	}
+}
+
+// WithDrainWindow set window of closing connections on shutdown and count of
+// connections closed in each batch
+func WithDrainWindow(window time.Duration, batchSize int) ServerOption {
+	return func(s *Server) {
+		s.drainWindow = window
+		if batchSize > 0 {
+			s.drainBatchSize = batchSize
+		}
+	}
+}
+
+// WithMessageBus set message bus push messages are published to, server
+// uses InProcessBus by default, with a bus shared by gateway nodes every node
+// fans out messages from bus to its local connections
+func WithMessageBus(bus MessageBus) ServerOption {
+	return func(s *Server) {
+		s.bus = bus
+	}
+}
+
+// WithPresence keep presence registry updated with members of private apps
+// connected to node, in cluster mode private pushes are routed only to nodes
+// holding connections of member, leases of node expire after ttl unless renewed
+func WithPresence(registry PresenceRegistry, node string, ttl time.Duration) ServerOption {
+	return func(s *Server) {
+		s.presenceRegistry = registry
+		s.node = node
+		s.presenceTTL = ttl
+	}
+}
@@ -0,0 +1,42 @@
+package gateway
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// authCookieToken returns token of auth cookie when it is the credential of
+// upgrade request, bearer token in Authorization header takes precedence
+func (g *Server) authCookieToken(r *http.Request) (string, bool) {
+	if strings.HasPrefix(r.Header.Get("Authorization"), bearerAuthPrefix) {
+		return "", false
+	}
+	cookie, err := r.Cookie(g.authCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// checkOrigin browsers send cookies of gateway with requests from any page,
+// so upgrade requests authenticated by auth cookie must come from gateway
+// origin or allowed origins, otherwise other sites could hijack sessions
+func (g *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if _, ok := g.authCookieToken(r); !ok {
+		return true
+	}
+	return isSameOrigin(r, origin) || g.allowedOrigins[strings.ToLower(origin)]
+}
+
+func isSameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
@@ -0,0 +1,186 @@
+package gateway
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const defaultPresenceTTL = time.Second * 30
+
+// PresenceRegistry shared registry of nodes holding connections of members
+// subscribed to private apps, entries are leases expired after ttl unless renewed
+type PresenceRegistry interface {
+	Register(ctx context.Context, app string, memberID int, node string, ttl time.Duration) error
+	Unregister(ctx context.Context, app string, memberID int, node string) error
+	// Nodes returns nodes holding unexpired leases of member
+	Nodes(ctx context.Context, app string, memberID int) ([]string, error)
+}
+
+// NodeBus MessageBus able to deliver messages to given nodes only, private
+// pushes are routed through it when presence registry is used
+type NodeBus interface {
+	MessageBus
+	PublishToNodes(ctx context.Context, nodes []string, pushMsg *PushMessage) error
+	// SubscribeNode deliver messages published to node to handler until ctx is done
+	SubscribeNode(ctx context.Context, node string, handler func(*PushMessage) error) error
+}
+
+type presenceKey struct {
+	app      string
+	memberID int
+}
+
+// presenceStore Store keeping presence registry updated with members of
+// private apps connected to node, leases are renewed every ttl/3 so entries
+// of a crashed node expire after ttl
+type presenceStore struct {
+	Store
+	apps     *AppRegistry
+	registry PresenceRegistry
+	node     string
+	ttl      time.Duration
+
+	mu sync.Mutex
+	// members connections of members of private apps on node
+	members map[presenceKey]map[string]struct{}
+	done    chan struct{}
+}
+
+func newPresenceStore(store Store, apps *AppRegistry, registry PresenceRegistry, node string, ttl time.Duration) *presenceStore {
+	if ttl <= 0 {
+		ttl = defaultPresenceTTL
+	}
+	ps := &presenceStore{
+		Store:    store,
+		apps:     apps,
+		registry: registry,
+		node:     node,
+		ttl:      ttl,
+		members:  make(map[presenceKey]map[string]struct{}),
+		done:     make(chan struct{}),
+	}
+	go ps.renewLoop()
+	return ps
+}
+
+// Save store connection and register member of private app on node
+func (ps *presenceStore) Save(ctx context.Context, app string, memberID int, ws Conn) error {
+	if err := ps.Store.Save(ctx, app, memberID, ws); err != nil {
+		return err
+	}
+	if !ps.apps.isPrivate(app) || !isValidMemberID(memberID) {
+		return nil
+	}
+
+	key := presenceKey{app, memberID}
+	ps.mu.Lock()
+	conns, registered := ps.members[key]
+	if !registered {
+		conns = make(map[string]struct{})
+		ps.members[key] = conns
+	}
+	conns[ws.ID()] = struct{}{}
+	ps.mu.Unlock()
+
+	if !registered {
+		ps.register(ctx, key)
+	}
+	return nil
+}
+
+// Delete delete connection and unregister members left without connection on node
+func (ps *presenceStore) Delete(ctx context.Context, memberID int, ws Conn) error {
+	apps, err := ps.Store.Subscriptions(ctx, ws.ID())
+	if err != nil {
+		return err
+	}
+	if err := ps.Store.Delete(ctx, memberID, ws); err != nil {
+		return err
+	}
+	for _, app := range apps {
+		if ps.apps.isPrivate(app) {
+			ps.release(ctx, presenceKey{app, memberID}, ws.ID())
+		}
+	}
+	return nil
+}
+
+// DeleteForApp delete connection from app and unregister member left without connection on node
+func (ps *presenceStore) DeleteForApp(ctx context.Context, app string, memberID int, ws Conn) error {
+	if err := ps.Store.DeleteForApp(ctx, app, memberID, ws); err != nil {
+		return err
+	}
+	if ps.apps.isPrivate(app) {
+		ps.release(ctx, presenceKey{app, memberID}, ws.ID())
+	}
+	return nil
+}
+
+// release unregister member when connID was the last connection of member on
+// node, a member saved again while unregistering is registered again, since
+// its lease may be removed by the unregister
+func (ps *presenceStore) release(ctx context.Context, key presenceKey, connID string) {
+	ps.mu.Lock()
+	conns, registered := ps.members[key]
+	if !registered {
+		ps.mu.Unlock()
+		return
+	}
+	delete(conns, connID)
+	if len(conns) > 0 {
+		ps.mu.Unlock()
+		return
+	}
+	delete(ps.members, key)
+	ps.mu.Unlock()
+
+	if err := ps.registry.Unregister(ctx, key.app, key.memberID, ps.node); err != nil {
+		log.Printf("unregister member %d of app %s failed: %v", key.memberID, key.app, err)
+	}
+
+	ps.mu.Lock()
+	_, savedAgain := ps.members[key]
+	ps.mu.Unlock()
+	if savedAgain {
+		ps.register(ctx, key)
+	}
+}
+
+func (ps *presenceStore) register(ctx context.Context, key presenceKey) {
+	if err := ps.registry.Register(ctx, key.app, key.memberID, ps.node, ps.ttl); err != nil {
+		log.Printf("register member %d of app %s failed: %v", key.memberID, key.app, err)
+	}
+}
+
+func (ps *presenceStore) renewLoop() {
+	ticker := time.NewTicker(ps.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ps.renew()
+		case <-ps.done:
+			return
+		}
+	}
+}
+
+func (ps *presenceStore) renew() {
+	ps.mu.Lock()
+	keys := make([]presenceKey, 0, len(ps.members))
+	for key := range ps.members {
+		keys = append(keys, key)
+	}
+	ps.mu.Unlock()
+
+	for _, key := range keys {
+		ps.register(context.Background(), key)
+	}
+}
+
+// close stop renewing leases
+func (ps *presenceStore) close() {
+	close(ps.done)
+}
@@ -0,0 +1,175 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+)
+
+// recordingBus records nodes private pushes are published to
+type recordingBus struct {
+	*RedisBus
+	mu        sync.Mutex
+	published [][]string
+}
+
+func (b *recordingBus) PublishToNodes(ctx context.Context, nodes []string, pushMsg *PushMessage) error {
+	b.mu.Lock()
+	b.published = append(b.published, nodes)
+	b.mu.Unlock()
+	return b.RedisBus.PublishToNodes(ctx, nodes, pushMsg)
+}
+
+func (b *recordingBus) publishedNodes() [][]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([][]string(nil), b.published...)
+}
+
+func newRedisPresence(mr *miniredis.Miniredis) *RedisPresence {
+	return NewRedisPresence(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "")
+}
+
+func mustNodes(t *testing.T, presence PresenceRegistry, app string, memberID int) []string {
+	t.Helper()
+	nodes, err := presence.Nodes(context.Background(), app, memberID)
+	assertNoError(t, err)
+	return nodes
+}
+
+func TestPresenceRouting(t *testing.T) {
+	mr, err := miniredis.Run()
+	assertNoError(t, err)
+	defer mr.Close()
+
+	presence := newRedisPresence(mr)
+	bus1 := &recordingBus{RedisBus: newRedisBus(t, mr)}
+	gateway1 := NewGatewayServer(NewInMemeryWSClientStore(), &FakeAuthServer{},
+		WithMessageBus(bus1), WithPresence(presence, "node-1", time.Second))
+	gateway2 := NewGatewayServer(NewInMemeryWSClientStore(), &FakeAuthServer{},
+		WithMessageBus(newRedisBus(t, mr)), WithPresence(presence, "node-2", time.Second))
+	server1 := httptest.NewServer(gateway1)
+	defer server1.Close()
+	server2 := httptest.NewServer(gateway2)
+	defer server2.Close()
+
+	ws1 := mustConnectAndAuthAndSubscribe(t, server1, 123456, "654321", "match")
+	defer ws1.Close()
+	ws2 := mustConnectAndAuthAndSubscribe(t, server2, 123456, "654321", imApp)
+	defer ws2.Close()
+	assertEqual(t, mustNodes(t, presence, imApp, 123456), []string{"node-2"})
+
+	response := httptest.NewRecorder()
+	gateway1.ServeHTTP(response, newPushMessagePostRequest(imApp, 123456, "hi"))
+	assertStatusCode(t, response.Code, http.StatusAccepted)
+	assertMessage(t, mustReadMessageWithTimeout(t, ws2, time.Millisecond*100), `{"app":"im","member_id":123456,"text":"hi"}`)
+	assertEqual(t, bus1.publishedNodes(), [][]string{{"node-2"}})
+
+	mustWriteMessage(t, ws2, `{"action":"unsubscribe","app":"im"}`)
+	mustReadMessageWithTimeout(t, ws2, time.Millisecond*100)
+	assertEqual(t, len(mustNodes(t, presence, imApp, 123456)), 0)
+
+	response = httptest.NewRecorder()
+	gateway1.ServeHTTP(response, newPushMessagePostRequest(imApp, 123456, "offline"))
+	assertStatusCode(t, response.Code, http.StatusAccepted)
+	assertEqual(t, len(bus1.publishedNodes()), 1)
+}
+
+func TestPresenceStore(t *testing.T) {
+	mr, err := miniredis.Run()
+	assertNoError(t, err)
+	defer mr.Close()
+
+	ctx := context.Background()
+	presence := newRedisPresence(mr)
+	store := newPresenceStore(NewInMemeryWSClientStore(), DefaultAppRegistry(), presence, "node-1", time.Millisecond*60)
+	defer store.close()
+
+	conn1 := &StubWSConn{id: "conn-1"}
+	conn2 := &StubWSConn{id: "conn-2"}
+	assertNoError(t, store.Save(ctx, imApp, 123, conn1))
+	assertNoError(t, store.Save(ctx, imApp, 123, conn2))
+	assertNoError(t, store.Save(ctx, "match", 123, conn1))
+	assertEqual(t, mustNodes(t, presence, imApp, 123), []string{"node-1"})
+
+	t.Run("lease is renewed", func(t *testing.T) {
+		time.Sleep(time.Millisecond * 200)
+		assertEqual(t, mustNodes(t, presence, imApp, 123), []string{"node-1"})
+	})
+
+	t.Run("member is unregistered with last connection", func(t *testing.T) {
+		assertNoError(t, store.Delete(ctx, 123, conn1))
+		assertEqual(t, mustNodes(t, presence, imApp, 123), []string{"node-1"})
+		assertNoError(t, store.DeleteForApp(ctx, imApp, 123, conn2))
+		assertEqual(t, len(mustNodes(t, presence, imApp, 123)), 0)
+	})
+}
+
+// gatedPresence blocks Unregister until gate is closed
+type gatedPresence struct {
+	PresenceRegistry
+	unregistering chan struct{}
+	gate          chan struct{}
+}
+
+func (p *gatedPresence) Unregister(ctx context.Context, app string, memberID int, node string) error {
+	p.unregistering <- struct{}{}
+	<-p.gate
+	return p.PresenceRegistry.Unregister(ctx, app, memberID, node)
+}
+
+func TestPresenceStoreSaveWhileReleasing(t *testing.T) {
+	mr, err := miniredis.Run()
+	assertNoError(t, err)
+	defer mr.Close()
+
+	ctx := context.Background()
+	presence := &gatedPresence{
+		PresenceRegistry: newRedisPresence(mr),
+		unregistering:    make(chan struct{}),
+		gate:             make(chan struct{}),
+	}
+	store := newPresenceStore(NewInMemeryWSClientStore(), DefaultAppRegistry(), presence, "node-1", time.Second)
+	defer store.close()
+
+	assertNoError(t, store.Save(ctx, imApp, 123, &StubWSConn{id: "conn-1"}))
+
+	released := make(chan error)
+	go func() {
+		released <- store.Delete(ctx, 123, &StubWSConn{id: "conn-1"})
+	}()
+	<-presence.unregistering
+	assertNoError(t, store.Save(ctx, imApp, 123, &StubWSConn{id: "conn-2"}))
+	close(presence.gate)
+	assertNoError(t, <-released)
+
+	assertEqual(t, mustNodes(t, presence, imApp, 123), []string{"node-1"})
+	store.mu.Lock()
+	_, renewed := store.members[presenceKey{imApp, 123}]
+	store.mu.Unlock()
+	assertEqual(t, renewed, true)
+}
+
+func TestRedisPresenceLeaseExpired(t *testing.T) {
+	mr, err := miniredis.Run()
+	assertNoError(t, err)
+	defer mr.Close()
+
+	ctx := context.Background()
+	presence := newRedisPresence(mr)
+	assertNoError(t, presence.Register(ctx, imApp, 123, "crashed", time.Millisecond*20))
+	assertNoError(t, presence.Register(ctx, imApp, 123, "alive", time.Second))
+	assertEqual(t, len(mustNodes(t, presence, imApp, 123)), 2)
+
+	time.Sleep(time.Millisecond * 50)
+	assertEqual(t, mustNodes(t, presence, imApp, 123), []string{"alive"})
+
+	assertNoError(t, presence.Unregister(ctx, imApp, 123, "alive"))
+	assertEqual(t, len(mustNodes(t, presence, imApp, 123)), 0)
+}
@@ -0,0 +1,285 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// legacyProtocolVersion bare AuthMessage and SubscribeMessage frames
+	legacyProtocolVersion = 0
+	// envelopeProtocolVersion frames wrapped in Envelope
+	envelopeProtocolVersion = 1
+)
+
+const (
+	// rawJSONSubprotocol websocket subprotocol to opt in raw JSON mode
+	rawJSONSubprotocol = "ws-gateway.json"
+	// rawJSONFormat value of format query parameter to opt in raw JSON mode
+	rawJSONFormat = "json"
+)
+
+const (
+	authAction        = "auth"
+	subscribeAction   = "subscribe"
+	unsubscribeAction = "unsubscribe"
+	pingAction        = "ping"
+	listAction        = "list"
+)
+
+// Envelope versioned client message, every gateway reply echoes ID
+type Envelope struct {
+	V      int             `json:"v"`
+	ID     string          `json:"id"`
+	Action string          `json:"action"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// envelopeData data of all envelope actions, Since and Last ask for
+// history of app when subscribing
+type envelopeData struct {
+	App      string  `json:"app"`
+	MemberID int     `json:"member_id"`
+	Token    string  `json:"token"`
+	Since    *uint64 `json:"since"`
+	Last     int     `json:"last"`
+}
+
+// legacyMessage v0 client message, which is a SubscribeMessage,
+// an unsubscribe message or an AuthMessage
+type legacyMessage struct {
+	V        int     `json:"v"`
+	ID       string  `json:"id"`
+	Action   string  `json:"action"`
+	App      string  `json:"app"`
+	MemberID int     `json:"member_id"`
+	Token    string  `json:"token"`
+	Since    *uint64 `json:"since"`
+	Last     int     `json:"last"`
+}
+
+// clientMessage client message of any protocol version
+type clientMessage struct {
+	id     string
+	action string
+	app    string
+	auth   AuthMessage
+	replay replayRequest
+}
+
+type protocolError struct {
+	id   string
+	text string
+}
+
+func (e *protocolError) Error() string {
+	return e.text
+}
+
+// parseClientMessage parse v1 Envelope or v0 legacy message
+func parseClientMessage(msg []byte) (clientMessage, error) {
+	var legacy legacyMessage
+	if err := json.Unmarshal(msg, &legacy); err != nil {
+		return clientMessage{}, &protocolError{text: badSubscribeMessageString}
+	}
+
+	switch legacy.V {
+	case legacyProtocolVersion:
+		return parseLegacyMessage(legacy)
+	case envelopeProtocolVersion:
+		return parseEnvelope(msg)
+	}
+	return clientMessage{}, &protocolError{id: legacy.ID, text: unsupportedVersionText(legacy.V)}
+}
+
+func parseLegacyMessage(legacy legacyMessage) (clientMessage, error) {
+	clientMsg := clientMessage{
+		id:     legacy.ID,
+		action: legacy.Action,
+		app:    legacy.App,
+		auth:   AuthMessage{MemberID: legacy.MemberID, Token: legacy.Token},
+		replay: replayRequest{since: legacy.Since, last: legacy.Last},
+	}
+
+	if clientMsg.action == "" {
+		clientMsg.action = subscribeAction
+		if legacy.App == "" && (legacy.MemberID != 0 || legacy.Token != "") {
+			clientMsg.action = authAction
+		}
+	}
+	return clientMsg, nil
+}
+
+func parseEnvelope(msg []byte) (clientMessage, error) {
+	var envelope Envelope
+	if err := json.Unmarshal(msg, &envelope); err != nil {
+		return clientMessage{}, &protocolError{text: badRequestText("bad envelope")}
+	}
+
+	var data envelopeData
+	if len(envelope.Data) > 0 {
+		if err := json.Unmarshal(envelope.Data, &data); err != nil {
+			return clientMessage{}, &protocolError{id: envelope.ID, text: badRequestText("bad envelope data")}
+		}
+	}
+
+	return clientMessage{
+		id:     envelope.ID,
+		action: envelope.Action,
+		app:    data.App,
+		auth:   AuthMessage{MemberID: data.MemberID, Token: data.Token},
+		replay: replayRequest{since: data.Since, last: data.Last},
+	}, nil
+}
+
+func unsupportedVersionText(v int) string {
+	return badRequestText(fmt.Sprintf("unsupported protocol version %d", v))
+}
+
+func badRequestText(message string) string {
+	return replyText(http.StatusBadRequest, message)
+}
+
+// replyText encode text of gateway reply, message may contain client
+// provided values so it is always escaped by json encoder
+func replyText(code int, message string) string {
+	m, _ := json.Marshal(struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}{code, message})
+	return string(m)
+}
+
+func helloText(message string, connID string) string {
+	m, _ := json.Marshal(struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		ConnID  string `json:"conn_id"`
+	}{http.StatusOK, message, connID})
+	return string(m)
+}
+
+func subscriptionsText(apps []string) string {
+	if apps == nil {
+		apps = []string{}
+	}
+	m, _ := json.Marshal(struct {
+		Code    int      `json:"code"`
+		Message string   `json:"message"`
+		Apps    []string `json:"apps"`
+	}{200, "subscriptions", apps})
+	return string(m)
+}
+
+// isRawJSONMode check if upgraded connection opts in raw JSON mode, in which
+// pushed payloads and gateway replies are embedded as JSON in data field
+func isRawJSONMode(r *http.Request, conn *websocket.Conn) bool {
+	return conn.Subprotocol() == rawJSONSubprotocol || r.URL.Query().Get("format") == rawJSONFormat
+}
+
+// rawJSONConn implemented by connections which may be in raw JSON mode
+type rawJSONConn interface {
+	rawJSON() bool
+}
+
+func isRawJSONConn(conn Conn) bool {
+	c, ok := conn.(rawJSONConn)
+	return ok && c.rawJSON()
+}
+
+// legacyPushFrame push message frame of connections not in raw JSON mode
+type legacyPushFrame struct {
+	ID       string `json:"id,omitempty"`
+	App      string `json:"app"`
+	MemberID int    `json:"member_id"`
+	Text     string `json:"text"`
+	Seq      uint64 `json:"seq,omitempty"`
+}
+
+// rawJSONPushFrame push message frame of connections in raw JSON mode
+type rawJSONPushFrame struct {
+	ID       string          `json:"id,omitempty"`
+	App      string          `json:"app"`
+	MemberID int             `json:"member_id"`
+	Data     json.RawMessage `json:"data"`
+	Seq      uint64          `json:"seq,omitempty"`
+}
+
+// encodePushMessage encode push message for connection of protocol mode,
+// it fails when Data is not valid JSON
+func encodePushMessage(pushMsg *PushMessage, rawJSON bool) ([]byte, error) {
+	if !rawJSON {
+		text := pushMsg.Text
+		if text == "" && len(pushMsg.Data) > 0 {
+			text = string(pushMsg.Data)
+		}
+		return json.Marshal(legacyPushFrame{pushMsg.ID, pushMsg.App, pushMsg.MemberID, text, pushMsg.Seq})
+	}
+
+	data := pushMsg.Data
+	if len(data) == 0 {
+		if json.Valid([]byte(pushMsg.Text)) {
+			data = json.RawMessage(pushMsg.Text)
+		} else {
+			var err error
+			if data, err = json.Marshal(pushMsg.Text); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return json.Marshal(rawJSONPushFrame{pushMsg.ID, pushMsg.App, pushMsg.MemberID, data, pushMsg.Seq})
+}
+
+// mustEncodePushMessage encode push message built by gateway, it panics
+// when encoding fails
+func mustEncodePushMessage(pushMsg *PushMessage, rawJSON bool) []byte {
+	m, err := encodePushMessage(pushMsg, rawJSON)
+	if err != nil {
+		panic(fmt.Sprintf("encode push message failed: %v", err))
+	}
+	return m
+}
+
+// pushFrames encodes push message once for each protocol mode, safe for
+// concurrent use by fan-out workers
+type pushFrames struct {
+	pushMsg     *PushMessage
+	legacyOnce  sync.Once
+	legacy      []byte
+	legacyErr   error
+	rawJSONOnce sync.Once
+	rawJSON     []byte
+	rawJSONErr  error
+}
+
+func newPushFrames(pushMsg *PushMessage) *pushFrames {
+	return &pushFrames{pushMsg: pushMsg}
+}
+
+// frameFor returns frame of connection protocol mode, encoding error is
+// logged once for each mode
+func (f *pushFrames) frameFor(conn Conn) ([]byte, error) {
+	if isRawJSONConn(conn) {
+		f.rawJSONOnce.Do(func() {
+			f.rawJSON, f.rawJSONErr = f.encode(true)
+		})
+		return f.rawJSON, f.rawJSONErr
+	}
+	f.legacyOnce.Do(func() {
+		f.legacy, f.legacyErr = f.encode(false)
+	})
+	return f.legacy, f.legacyErr
+}
+
+func (f *pushFrames) encode(rawJSON bool) ([]byte, error) {
+	m, err := encodePushMessage(f.pushMsg, rawJSON)
+	if err != nil {
+		log.Printf("encode push message of app %s failed: %v", f.pushMsg.App, err)
+	}
+	return m, err
+}
@@ -0,0 +1,96 @@
+package gateway
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEnvelopeProtocol(t *testing.T) {
+	store := NewInMemeryWSClientStore()
+	server := httptest.NewServer(NewGatewayServer(store, &FakeAuthServer{}))
+	defer server.Close()
+
+	ws, _ := mustConnectTo(t, server)
+	defer ws.Close()
+
+	read := func() string {
+		return mustReadMessageWithTimeout(t, ws, time.Millisecond*10)
+	}
+
+	mustWriteMessage(t, ws, `{"v":1,"id":"a1","action":"auth","data":{"member_id":123456,"token":"654321"}}`)
+	assertHelloMessage(t, read(), wrapGatewayReply("a1", helloMemberText(123456, "")))
+
+	mustWriteMessage(t, ws, `{"v":1,"id":"s1","action":"subscribe","data":{"app":"match"}}`)
+	assertMessage(t, read(), wrapGatewayReply("s1", subscribeSuccessText("match")))
+	assertWSClientCount(t, len(store.publicWSClientsForApp("match")), 1)
+
+	mustWriteMessage(t, ws, `{"v":1,"id":"s2","action":"subscribe","data":{"app":"im"}}`)
+	assertMessage(t, read(), wrapGatewayReply("s2", subscribeSuccessText(imApp)))
+
+	mustWriteMessage(t, ws, `{"v":1,"id":"l1","action":"list"}`)
+	assertMessage(t, read(), wrapGatewayReply("l1", `{"code":200,"message":"subscriptions","apps":["im","match"]}`))
+
+	mustWriteMessage(t, ws, `{"v":1,"id":"p1","action":"ping"}`)
+	assertMessage(t, read(), wrapGatewayReply("p1", pongMessageString))
+
+	mustWriteMessage(t, ws, `{"v":1,"id":"u1","action":"unsubscribe","data":{"app":"match"}}`)
+	assertMessage(t, read(), wrapGatewayReply("u1", unsubscribeSuccessText("match")))
+	assertWSClientCount(t, len(store.publicWSClientsForApp("match")), 0)
+
+	mustWriteMessage(t, ws, `{"v":1,"id":"s3","action":"subscribe","data":{}}`)
+	assertMessage(t, read(), wrapGatewayReply("s3", badSubscribeMessageString))
+
+	mustWriteMessage(t, ws, `{"v":1,"id":"x1","action":"publish"}`)
+	assertMessage(t, read(), wrapGatewayReply("x1", unknownActionText("publish")))
+
+	mustWriteMessage(t, ws, `{"v":2,"id":"v2","action":"ping"}`)
+	assertMessage(t, read(), wrapGatewayReply("v2", unsupportedVersionText(2)))
+
+	mustWriteMessage(t, ws, `{"v":1,"id":"a2","action":"auth","data":{"member_id":12345,"token":"65432"}}`)
+	assertMessage(t, read(), wrapGatewayReply("a2", unauthorizedMessageString))
+	assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, 123456)), 0)
+}
+
+func TestLegacyProtocol(t *testing.T) {
+	server, store := newServer()
+	defer server.Close()
+
+	ws, _ := mustConnectTo(t, server)
+	defer ws.Close()
+
+	mustSendAuthMessage(t, ws, 123456, "654321")
+	assertHelloMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10), helloMessageForMember(123456))
+
+	mustWriteMessage(t, ws, `{"id":"legacy","app":"match"}`)
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10), wrapGatewayReply("legacy", subscribeSuccessText("match")))
+
+	mustSendAuthMessage(t, ws, 123456, "654321")
+	assertHelloMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10), helloMessageForMember(123456))
+	assertWSClientCount(t, len(store.publicWSClientsForApp("match")), 1)
+}
+
+func TestParseClientMessage(t *testing.T) {
+	tests := []struct {
+		description string
+		msg         string
+		want        clientMessage
+	}{
+		{"legacy subscribe", `{"app":"match"}`, clientMessage{action: subscribeAction, app: "match"}},
+		{"legacy auth", `{"member_id":1,"token":"t"}`, clientMessage{action: authAction, auth: AuthMessage{MemberID: 1, Token: "t"}}},
+		{"legacy unsubscribe", `{"action":"unsubscribe","app":"match"}`, clientMessage{action: unsubscribeAction, app: "match"}},
+		{"envelope auth", `{"v":1,"id":"1","action":"auth","data":{"member_id":1,"token":"t"}}`, clientMessage{id: "1", action: authAction, auth: AuthMessage{MemberID: 1, Token: "t"}}},
+		{"envelope without data", `{"v":1,"id":"2","action":"ping"}`, clientMessage{id: "2", action: pingAction}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			got, err := parseClientMessage([]byte(tt.msg))
+			assertNoError(t, err)
+			assertEqual(t, got, tt.want)
+		})
+	}
+
+	_, err := parseClientMessage([]byte(`{"v":1,"id":"3","data":"bad"}`))
+	assertEqual(t, err, error(&protocolError{id: "3", text: badRequestText("bad envelope data")}))
+}
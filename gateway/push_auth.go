@@ -0,0 +1,168 @@
+package gateway
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	pushKeyHeader       = "X-Gateway-Key"
+	pushTimestampHeader = "X-Gateway-Timestamp"
+	pushSignatureHeader = "X-Gateway-Signature"
+
+	defaultPushMaxClockSkew = time.Minute * 5
+)
+
+// PushKey push API key, a key can only push to its apps
+type PushKey struct {
+	ID     string   `json:"id"`
+	Secret string   `json:"secret"`
+	Apps   []string `json:"apps"`
+}
+
+// pushAuthError push request auth error with response status code
+type pushAuthError struct {
+	status int
+	reason string
+}
+
+func (e *pushAuthError) Error() string {
+	return e.reason
+}
+
+func pushUnauthorized(format string, a ...interface{}) error {
+	return &pushAuthError{status: http.StatusUnauthorized, reason: fmt.Sprintf(format, a...)}
+}
+
+func pushForbidden(format string, a ...interface{}) error {
+	return &pushAuthError{status: http.StatusForbidden, reason: fmt.Sprintf(format, a...)}
+}
+
+// PushAuth authenticate push requests, every request must carry
+// X-Gateway-Key, X-Gateway-Timestamp (unix seconds) and X-Gateway-Signature
+// which is hex encoded HMAC-SHA256 of "<timestamp>.<body>" signed by key secret
+type PushAuth struct {
+	keys          map[string]PushKey
+	maxClockSkew  time.Duration
+	now           func() time.Time
+	mu            sync.Mutex
+	seenSignature map[string]time.Time
+	lastPurge     time.Time
+}
+
+// NewPushAuth create a new PushAuth, requests with timestamp not within
+// maxClockSkew are rejected
+func NewPushAuth(keys []PushKey, maxClockSkew time.Duration) *PushAuth {
+	if maxClockSkew <= 0 {
+		maxClockSkew = defaultPushMaxClockSkew
+	}
+	auth := &PushAuth{
+		keys:          make(map[string]PushKey),
+		maxClockSkew:  maxClockSkew,
+		now:           time.Now,
+		seenSignature: make(map[string]time.Time),
+	}
+	for _, key := range keys {
+		auth.keys[key.ID] = key
+	}
+	return auth
+}
+
+// LoadPushAuth create a PushAuth from JSON file like {"keys":[{"id":"","secret":"","apps":[]}]}
+func LoadPushAuth(path string, maxClockSkew time.Duration) (*PushAuth, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read push keys file failed: %v", err)
+	}
+
+	var config struct {
+		Keys []PushKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parse push keys file failed: %v", err)
+	}
+	return NewPushAuth(config.Keys, maxClockSkew), nil
+}
+
+// verify check push request signature, returns key of request
+func (a *PushAuth) verify(r *http.Request, body []byte) (PushKey, error) {
+	keyID := r.Header.Get(pushKeyHeader)
+	if keyID == "" {
+		return PushKey{}, pushUnauthorized("missing api key")
+	}
+	key, ok := a.keys[keyID]
+	if !ok {
+		return PushKey{}, pushUnauthorized("unknown api key %s", keyID)
+	}
+
+	timestamp := r.Header.Get(pushTimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return PushKey{}, pushUnauthorized("missing or bad timestamp")
+	}
+	now := a.now()
+	signedAt := time.Unix(seconds, 0)
+	if signedAt.Before(now.Add(-a.maxClockSkew)) || signedAt.After(now.Add(a.maxClockSkew)) {
+		return PushKey{}, pushUnauthorized("timestamp out of range")
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get(pushSignatureHeader))
+	if err != nil || len(signature) == 0 {
+		return PushKey{}, pushUnauthorized("missing or bad signature")
+	}
+	if !hmac.Equal(signature, signPushRequest(key.Secret, timestamp, body)) {
+		return PushKey{}, pushUnauthorized("invalid signature")
+	}
+
+	if a.isReplayed(string(signature), signedAt) {
+		return PushKey{}, pushUnauthorized("replayed request")
+	}
+	return key, nil
+}
+
+// authorize check if key can push to app
+func (a *PushAuth) authorize(key PushKey, app string) error {
+	if !containsString(key.Apps, app) {
+		return pushForbidden("api key %s can not push to app %s", key.ID, app)
+	}
+	return nil
+}
+
+// isReplayed records signature until it can not pass timestamp check,
+// returns true if signature has been seen
+func (a *PushAuth) isReplayed(signature string, signedAt time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	if now.Sub(a.lastPurge) > time.Second {
+		for s, expiresAt := range a.seenSignature {
+			if now.After(expiresAt) {
+				delete(a.seenSignature, s)
+			}
+		}
+		a.lastPurge = now
+	}
+
+	if _, ok := a.seenSignature[signature]; ok {
+		return true
+	}
+	a.seenSignature[signature] = signedAt.Add(a.maxClockSkew)
+	return false
+}
+
+func signPushRequest(secret, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,76 @@
+package gateway
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPushAuth(t *testing.T) {
+	store := newStubWSStore()
+	pushAuth := NewPushAuth([]PushKey{
+		{ID: "match-producer", Secret: "match-secret", Apps: []string{"match"}},
+	}, time.Minute)
+	server := NewGatewayServer(store, &FakeAuthServer{}, WithPushAuth(pushAuth))
+
+	now := time.Now().Unix()
+	signed := func(keyID, secret string, timestamp int64, body []byte) *http.Request {
+		request := httptest.NewRequest(http.MethodPost, pushURLPath, strings.NewReader(string(body)))
+		ts := strconv.FormatInt(timestamp, 10)
+		request.Header.Set(pushKeyHeader, keyID)
+		request.Header.Set(pushTimestampHeader, ts)
+		request.Header.Set(pushSignatureHeader, hex.EncodeToString(signPushRequest(secret, ts, body)))
+		return request
+	}
+	matchBody := pushMessageJSONFor("match", anonymousMemberID, `{"hello":"world"}`)
+	imBody := pushMessageJSONFor(imApp, 123456, `{"hello":"world"}`)
+
+	tests := []struct {
+		description string
+		request     *http.Request
+		wantStatus  int
+	}{
+		{"signed request", signed("match-producer", "match-secret", now, matchBody), http.StatusAccepted},
+		{"missing api key", newPushMessagePostRequest("match", anonymousMemberID, `{"hello":"world"}`), http.StatusUnauthorized},
+		{"unknown api key", signed("im-producer", "match-secret", now, matchBody), http.StatusUnauthorized},
+		{"wrong secret", signed("match-producer", "wrong-secret", now, matchBody), http.StatusUnauthorized},
+		{"stale timestamp", signed("match-producer", "match-secret", now-120, matchBody), http.StatusUnauthorized},
+		{"push to app out of scope", signed("match-producer", "match-secret", now, imBody), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, tt.request)
+			assertStatusCode(t, response.Code, tt.wantStatus)
+		})
+	}
+
+	t.Run("replayed request", func(t *testing.T) {
+		body := pushMessageJSONFor("match", anonymousMemberID, `{"replay":"test"}`)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, signed("match-producer", "match-secret", now, body))
+		assertStatusCode(t, response.Code, http.StatusAccepted)
+
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, signed("match-producer", "match-secret", now, body))
+		assertStatusCode(t, response.Code, http.StatusUnauthorized)
+		assertEqual(t, strings.Contains(response.Body.String(), "replayed request"), true)
+	})
+}
+
+func TestLoadPushAuth(t *testing.T) {
+	dir := mustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	path := mustWriteFile(t, dir, "push-keys.json", []byte(`{"keys":[{"id":"im-producer","secret":"im-secret","apps":["im"]}]}`))
+	pushAuth, err := LoadPushAuth(path, 0)
+	assertNoError(t, err)
+	assertEqual(t, pushAuth.keys["im-producer"].Apps, []string{imApp})
+	assertEqual(t, pushAuth.maxClockSkew, defaultPushMaxClockSkew)
+}
@@ -0,0 +1,350 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PushQueuePolicy decides what to do when push queue is full
+type PushQueuePolicy string
+
+const (
+	// PushQueueBlock wait for queue space until BlockTimeout, then reject
+	PushQueueBlock PushQueuePolicy = "block"
+	// PushQueueReject reject push request immediately
+	PushQueueReject PushQueuePolicy = "reject"
+	// PushQueueSpill spill push message to disk spool, spooled messages are
+	// queued in order when queue has space
+	PushQueueSpill PushQueuePolicy = "spill"
+)
+
+const (
+	defaultPushQueueSize     = 1000
+	defaultPushBlockTimeout  = time.Second * 5
+	defaultPushRetryAfter    = time.Second
+	pushSpoolFileName        = "push-spool.jsonl"
+	pushQueueOverloadMessage = "push queue is full, retry later"
+)
+
+// PushQueueConfig config of push queue, rejected push requests are answered
+// with 503 and Retry-After header
+type PushQueueConfig struct {
+	Size         int
+	Policy       PushQueuePolicy
+	BlockTimeout time.Duration
+	RetryAfter   time.Duration
+	Spool        *PushSpool
+}
+
+func defaultPushQueueConfig() PushQueueConfig {
+	return PushQueueConfig{
+		Size:         defaultPushQueueSize,
+		Policy:       PushQueueBlock,
+		BlockTimeout: defaultPushBlockTimeout,
+		RetryAfter:   defaultPushRetryAfter,
+	}
+}
+
+// ParsePushQueuePolicy parse policy name
+func ParsePushQueuePolicy(name string) (PushQueuePolicy, error) {
+	policy := PushQueuePolicy(name)
+	switch policy {
+	case PushQueueBlock, PushQueueReject, PushQueueSpill:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown push queue policy %q", name)
+}
+
+// pushQueue queue of push messages waiting for fan-out
+type pushQueue struct {
+	config   PushQueueConfig
+	messages chan *PushMessage
+
+	rejected int64
+}
+
+func newPushQueue(config PushQueueConfig) *pushQueue {
+	if config.Size <= 0 {
+		config.Size = defaultPushQueueSize
+	}
+	if config.RetryAfter <= 0 {
+		config.RetryAfter = defaultPushRetryAfter
+	}
+	q := &pushQueue{
+		config:   config,
+		messages: make(chan *PushMessage, config.Size),
+	}
+	if config.Policy == PushQueueSpill && config.Spool != nil {
+		go config.Spool.replay(q.messages)
+	}
+	return q
+}
+
+// enqueue add push message to queue, returns false when push should be rejected
+func (q *pushQueue) enqueue(pushMsg *PushMessage) bool {
+	if q.config.Policy == PushQueueBlock {
+		return q.block(pushMsg)
+	}
+	return q.offer(pushMsg)
+}
+
+// offer add push message to queue without waiting for space, messages are
+// still spilled to spool under PushQueueSpill
+func (q *pushQueue) offer(pushMsg *PushMessage) bool {
+	if q.spilling() {
+		return q.spill(pushMsg)
+	}
+
+	select {
+	case q.messages <- pushMsg:
+		return true
+	default:
+		atomic.AddInt64(&q.rejected, 1)
+		return false
+	}
+}
+
+// rejectFull counts a rejected push and returns true when queue has no
+// space left for a message which can not be spilled
+func (q *pushQueue) rejectFull() bool {
+	if q.spilling() || len(q.messages) < cap(q.messages) {
+		return false
+	}
+	atomic.AddInt64(&q.rejected, 1)
+	return true
+}
+
+func (q *pushQueue) spilling() bool {
+	return q.config.Policy == PushQueueSpill && q.config.Spool != nil
+}
+
+func (q *pushQueue) block(pushMsg *PushMessage) bool {
+	select {
+	case q.messages <- pushMsg:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(q.config.BlockTimeout)
+	defer timer.Stop()
+	select {
+	case q.messages <- pushMsg:
+		return true
+	case <-timer.C:
+		atomic.AddInt64(&q.rejected, 1)
+		return false
+	}
+}
+
+// spill queue message directly when nothing is spooled, otherwise append it
+// to spool to keep push order
+func (q *pushQueue) spill(pushMsg *PushMessage) bool {
+	spool := q.config.Spool
+	if spool.enqueueOrAppend(q.messages, pushMsg) {
+		return true
+	}
+	atomic.AddInt64(&q.rejected, 1)
+	return false
+}
+
+// drain wait for spooled messages to be queued, then close queue so push
+// loop returns after queued messages are delivered, no message can be
+// enqueued after drain
+func (q *pushQueue) drain(ctx context.Context) error {
+	if q.config.Policy == PushQueueSpill && q.config.Spool != nil {
+		ticker := time.NewTicker(time.Millisecond * 10)
+		defer ticker.Stop()
+		for q.config.Spool.depth() > 0 {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	close(q.messages)
+	return nil
+}
+
+func (q *pushQueue) retryAfter() string {
+	seconds := int(q.config.RetryAfter.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
+
+func (q *pushQueue) counters() []wsCount {
+	result := []wsCount{
+		{"push_queue_depth", len(q.messages)},
+		{"push_rejected", int(atomic.LoadInt64(&q.rejected))},
+	}
+	if q.config.Policy == PushQueueSpill && q.config.Spool != nil {
+		result = append(result,
+			wsCount{"push_spilled", int(atomic.LoadInt64(&q.config.Spool.spilled))},
+			wsCount{"push_spool_depth", q.config.Spool.depth()},
+		)
+	}
+	return result
+}
+
+// PushSpool disk spool of push messages which do not fit in push queue,
+// a spooled message is done only after it is replayed and fanned out
+type PushSpool struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	writer  *os.File
+	reader  *os.File
+	buf     *bufio.Reader
+	pending int
+	// dones done hooks of pending messages in spool order, spooled messages
+	// are fanned out before they are done
+	dones []func()
+
+	spilled int64
+}
+
+// NewPushSpool create a new PushSpool in dir, messages left by previous run are replayed
+func NewPushSpool(dir string) (*PushSpool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create push spool dir failed: %v", err)
+	}
+
+	path := filepath.Join(dir, pushSpoolFileName)
+	writer, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open push spool failed: %v", err)
+	}
+	reader, err := os.Open(path)
+	if err != nil {
+		writer.Close()
+		return nil, fmt.Errorf("open push spool failed: %v", err)
+	}
+
+	spool := &PushSpool{
+		writer: writer,
+		reader: reader,
+		buf:    bufio.NewReader(reader),
+	}
+	spool.cond = sync.NewCond(&spool.mu)
+	if spool.pending, err = countLines(path); err != nil {
+		spool.Close()
+		return nil, err
+	}
+	spool.dones = make([]func(), spool.pending)
+	return spool, nil
+}
+
+func countLines(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("read push spool failed: %v", err)
+	}
+	defer f.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<24)
+	for scanner.Scan() {
+		count++
+	}
+	return count, scanner.Err()
+}
+
+// enqueueOrAppend queue message when spool is empty and queue has space,
+// otherwise append message to spool
+func (s *PushSpool) enqueueOrAppend(messages chan *PushMessage, pushMsg *PushMessage) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending == 0 {
+		select {
+		case messages <- pushMsg:
+			return true
+		default:
+		}
+	}
+
+	line, _ := json.Marshal(pushMsg)
+	if _, err := s.writer.Write(append(line, '\n')); err != nil {
+		log.Printf("spill push message failed: %v", err)
+		return false
+	}
+	s.pending++
+	s.dones = append(s.dones, pushMsg.done)
+	atomic.AddInt64(&s.spilled, 1)
+	s.cond.Signal()
+	return true
+}
+
+// replay move spooled messages to queue in order, spool file is truncated
+// whenever all spooled messages are queued
+func (s *PushSpool) replay(messages chan *PushMessage) {
+	for {
+		s.mu.Lock()
+		for s.pending == 0 {
+			s.cond.Wait()
+		}
+		s.mu.Unlock()
+
+		line, err := s.buf.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			log.Printf("read push spool failed: %v", err)
+			return
+		}
+
+		s.mu.Lock()
+		done := s.dones[0]
+		s.dones = s.dones[1:]
+		s.mu.Unlock()
+
+		var pushMsg PushMessage
+		if err := json.Unmarshal(line, &pushMsg); err != nil {
+			log.Printf("drop bad spooled push message %q: %v", line, err)
+			pushMsg.done = done
+			pushMsg.finish()
+		} else {
+			pushMsg.done = done
+			messages <- &pushMsg
+		}
+
+		s.mu.Lock()
+		s.pending--
+		if s.pending == 0 {
+			s.truncate()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// truncate empty spool file, must hold s.mu
+func (s *PushSpool) truncate() {
+	if err := s.writer.Truncate(0); err != nil {
+		log.Printf("truncate push spool failed: %v", err)
+		return
+	}
+	s.reader.Seek(0, io.SeekStart)
+	s.buf.Reset(s.reader)
+}
+
+func (s *PushSpool) depth() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending
+}
+
+// Close close spool files
+func (s *PushSpool) Close() error {
+	s.reader.Close()
+	return s.writer.Close()
+}
@@ -0,0 +1,174 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// gatedWSStore blocks fan-out until gate is closed, so push queue fills up
+type gatedWSStore struct {
+	*StubWSStore
+	gate chan struct{}
+}
+
+func newGatedWSStore() *gatedWSStore {
+	return &gatedWSStore{
+		StubWSStore: newStubWSStore(),
+		gate:        make(chan struct{}),
+	}
+}
+
+func (s *gatedWSStore) PublicConns(ctx context.Context, app string) ([]Conn, error) {
+	<-s.gate
+	return s.StubWSStore.PublicConns(ctx, app)
+}
+
+func pushTo(server *Server, text string) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	server.ServeHTTP(response, newPushMessagePostRequest("match", anonymousMemberID, text))
+	return response
+}
+
+func counterOf(server *Server, name string) int {
+	for _, c := range server.counters() {
+		if c.name == name {
+			return c.count
+		}
+	}
+	return -1
+}
+
+func TestPushQueueReject(t *testing.T) {
+	store := newGatedWSStore()
+	defer close(store.gate)
+	server := NewGatewayServer(store, &FakeAuthServer{}, WithPushQueue(PushQueueConfig{
+		Size:       1,
+		Policy:     PushQueueReject,
+		RetryAfter: time.Second * 2,
+	}))
+
+	assertStatusCode(t, pushTo(server, "1").Code, http.StatusAccepted)
+	time.Sleep(time.Millisecond * 10)
+	assertStatusCode(t, pushTo(server, "2").Code, http.StatusAccepted)
+
+	response := pushTo(server, "3")
+	assertStatusCode(t, response.Code, http.StatusServiceUnavailable)
+	assertEqual(t, response.Header().Get("Retry-After"), "2")
+	assertEqual(t, counterOf(server, "push_queue_depth"), 1)
+	assertEqual(t, counterOf(server, "push_rejected"), 1)
+}
+
+func TestPushQueueBlock(t *testing.T) {
+	store := newGatedWSStore()
+	server := NewGatewayServer(store, &FakeAuthServer{}, WithPushQueue(PushQueueConfig{
+		Size:         1,
+		Policy:       PushQueueBlock,
+		BlockTimeout: time.Millisecond * 50,
+	}))
+
+	assertStatusCode(t, pushTo(server, "1").Code, http.StatusAccepted)
+	time.Sleep(time.Millisecond * 10)
+	assertStatusCode(t, pushTo(server, "2").Code, http.StatusAccepted)
+
+	response := pushTo(server, "3")
+	assertStatusCode(t, response.Code, http.StatusServiceUnavailable)
+	assertEqual(t, response.Header().Get("Retry-After"), "1")
+
+	time.AfterFunc(time.Millisecond*10, func() {
+		close(store.gate)
+	})
+	assertStatusCode(t, pushTo(server, "4").Code, http.StatusAccepted)
+}
+
+func TestPushQueueSpill(t *testing.T) {
+	dir := mustTempDir(t)
+	defer os.RemoveAll(dir)
+	spool, err := NewPushSpool(dir)
+	assertNoError(t, err)
+	defer spool.Close()
+
+	store := newGatedWSStore()
+	ws := newStubWSConn("1")
+	store.save("match", anonymousMemberID, ws)
+	server := NewGatewayServer(store, &FakeAuthServer{}, WithPushQueue(PushQueueConfig{
+		Size:   1,
+		Policy: PushQueueSpill,
+		Spool:  spool,
+	}))
+
+	messageCount := 5
+	for i := 0; i < messageCount; i++ {
+		assertStatusCode(t, pushTo(server, fmt.Sprint(i)).Code, http.StatusAccepted)
+	}
+	assertEqual(t, counterOf(server, "push_spilled") > 0, true)
+	assertEqual(t, counterOf(server, "push_rejected"), 0)
+
+	close(store.gate)
+	time.Sleep(time.Millisecond * 50)
+
+	messages := ws.messages()
+	assertBufferLengthEqual(t, len(messages), messageCount)
+	for i, msg := range messages {
+		assertMessage(t, string(msg), string(pushMessageJSONFor("match", anonymousMemberID, fmt.Sprint(i))))
+	}
+	assertEqual(t, counterOf(server, "push_spool_depth"), 0)
+
+	info, err := os.Stat(filepath.Join(dir, pushSpoolFileName))
+	assertNoError(t, err)
+	assertEqual(t, info.Size(), int64(0))
+}
+
+func TestPushSpoolReplayOnStart(t *testing.T) {
+	dir := mustTempDir(t)
+	defer os.RemoveAll(dir)
+	mustWriteFile(t, dir, pushSpoolFileName, append(pushMessageJSONFor("match", anonymousMemberID, "left"), '\n'))
+
+	spool, err := NewPushSpool(dir)
+	assertNoError(t, err)
+	defer spool.Close()
+	assertEqual(t, spool.depth(), 1)
+
+	messages := make(chan *PushMessage, 1)
+	go spool.replay(messages)
+	select {
+	case pushMsg := <-messages:
+		assertEqual(t, pushMsg.Text, "left")
+	case <-time.After(time.Second):
+		t.Fatal("spooled message was not replayed")
+	}
+}
+
+func TestPushSpoolDoneAfterFanout(t *testing.T) {
+	dir := mustTempDir(t)
+	defer os.RemoveAll(dir)
+	spool, err := NewPushSpool(dir)
+	assertNoError(t, err)
+	defer spool.Close()
+
+	finished := make(chan struct{})
+	pushMsg := &PushMessage{App: "match", MemberID: anonymousMemberID, Text: "spilled", done: func() { close(finished) }}
+	messages := make(chan *PushMessage)
+	assertEqual(t, spool.enqueueOrAppend(messages, pushMsg), true)
+
+	go spool.replay(messages)
+	replayed := <-messages
+	assertEqual(t, replayed.Text, "spilled")
+	select {
+	case <-finished:
+		t.Fatal("spooled message is done before fan-out")
+	case <-time.After(time.Millisecond * 10):
+	}
+
+	replayed.finish()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("spooled message is not done after fan-out")
+	}
+}
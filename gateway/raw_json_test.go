@@ -0,0 +1,111 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRawJSONMode(t *testing.T) {
+	store := NewInMemeryWSClientStore()
+	gateway := NewGatewayServer(store, &FakeAuthServer{})
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	tests := []struct {
+		description string
+		query       string
+		header      http.Header
+	}{
+		{"opt in by subprotocol", "", http.Header{"Sec-WebSocket-Protocol": {rawJSONSubprotocol}}},
+		{"opt in by query", "?format=json", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			ws, _, err := dialWithRequest(server, tt.query, tt.header)
+			assertNoError(t, err)
+			defer ws.Close()
+
+			mustSendAuthMessage(t, ws, 123456, "654321")
+			assertHelloMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10),
+				`{"app":"gateway","member_id":-1,"data":{"code":200,"message":"hello 123456","conn_id":""}}`)
+
+			mustWriteMessage(t, ws, `{"v":1,"id":"s1","action":"subscribe","data":{"app":"match"}}`)
+			assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10),
+				wrapGatewayRawJSONReply("s1", subscribeSuccessText("match")))
+
+			response := httptest.NewRecorder()
+			gateway.ServeHTTP(response, newPushMessagePostRequest("match", anonymousMemberID, `{"hello":"world"}`))
+			assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10),
+				`{"app":"match","member_id":-1,"data":{"hello":"world"}}`)
+
+			mustWriteMessage(t, ws, `{"v":1,"id":"u1","action":"unsubscribe","data":{"app":"match"}}`)
+			mustReadMessageWithTimeout(t, ws, time.Millisecond*10)
+		})
+	}
+}
+
+func TestRawJSONModeNotOptIn(t *testing.T) {
+	store := NewInMemeryWSClientStore()
+	gateway := NewGatewayServer(store, &FakeAuthServer{})
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	ws := mustConnectAndAuthAndSubscribe(t, server, 123456, "654321", "match")
+	defer ws.Close()
+
+	response := httptest.NewRecorder()
+	gateway.ServeHTTP(response, newPushMessagePostRequest("match", anonymousMemberID, `{"hello":"world"}`))
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10),
+		`{"app":"match","member_id":-1,"text":"{\"hello\":\"world\"}"}`)
+}
+
+func TestEncodePushMessage(t *testing.T) {
+	tests := []struct {
+		description string
+		pushMsg     PushMessage
+		rawJSON     bool
+		want        string
+	}{
+		{"text as legacy", PushMessage{App: "match", MemberID: -1, Text: "hi"}, false, `{"app":"match","member_id":-1,"text":"hi"}`},
+		{"data as legacy", PushMessage{App: "match", MemberID: -1, Data: []byte(`{"a":1}`)}, false, `{"app":"match","member_id":-1,"text":"{\"a\":1}"}`},
+		{"json text as raw json", PushMessage{App: "match", MemberID: -1, Text: `{"a":1}`}, true, `{"app":"match","member_id":-1,"data":{"a":1}}`},
+		{"plain text as raw json", PushMessage{App: "match", MemberID: -1, Text: "hi"}, true, `{"app":"match","member_id":-1,"data":"hi"}`},
+		{"data as raw json", PushMessage{App: "match", MemberID: -1, Data: []byte(`[1,2]`)}, true, `{"app":"match","member_id":-1,"data":[1,2]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			m, err := encodePushMessage(&tt.pushMsg, tt.rawJSON)
+			assertNoError(t, err)
+			assertEqual(t, string(m), tt.want)
+		})
+	}
+
+	t.Run("invalid data as raw json", func(t *testing.T) {
+		_, err := encodePushMessage(&PushMessage{App: "match", MemberID: -1, Data: []byte(`{`)}, true)
+		assertError(t, err)
+	})
+}
+
+func TestRawJSONModeQuotedApp(t *testing.T) {
+	gateway := NewGatewayServer(NewInMemeryWSClientStore(), &FakeAuthServer{})
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	ws, _, err := dialWithRequest(server, "?format=json", nil)
+	assertNoError(t, err)
+	defer ws.Close()
+	mustSendAuthMessage(t, ws, 123456, "654321")
+	mustReadMessageWithTimeout(t, ws, time.Millisecond*10)
+
+	mustWriteMessage(t, ws, `{"v":1,"id":"s1","action":"subscribe","data":{"app":"x\"y"}}`)
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10),
+		`{"id":"s1","app":"gateway","member_id":-1,"data":{"code":200,"message":"subscribe x\"y success"}}`)
+
+	mustWriteMessage(t, ws, `{"v":1,"id":"x1","action":"a\"b"}`)
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10),
+		`{"id":"x1","app":"gateway","member_id":-1,"data":{"code":400,"message":"unknown action a\"b"}}`)
+}
@@ -0,0 +1,106 @@
+package gateway
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const refreshedToken = "refreshed"
+
+// expiringAuthServer implements Authenticator which identity expires after ttl,
+// identity of refreshedToken expires after one second
+type expiringAuthServer struct {
+	ttl time.Duration
+}
+
+func (s *expiringAuthServer) Auth(memberID int, token string) bool {
+	return token == "654321" || token == refreshedToken
+}
+
+func (s *expiringAuthServer) Authenticate(ctx context.Context, auth AuthMessage) (Identity, error) {
+	if !s.Auth(auth.MemberID, auth.Token) {
+		return Identity{}, ErrUnauthorized
+	}
+	ttl := s.ttl
+	if auth.Token == refreshedToken {
+		ttl = time.Second
+	}
+	return Identity{MemberID: auth.MemberID, ExpiresAt: time.Now().Add(ttl)}, nil
+}
+
+func TestReauth(t *testing.T) {
+	server, store := newServer()
+	defer server.Close()
+
+	ws, _ := mustConnectTo(t, server)
+	defer ws.Close()
+	mustSendAuthMessage(t, ws, anonymousMemberID, "")
+	assertHelloMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10), helloStrangerMessage())
+	assertSubscribe(t, ws, []string{imApp}, false)
+
+	mustSendAuthMessage(t, ws, 123456, "654321")
+	assertHelloMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10), helloMessageForMember(123456))
+	assertSubscribe(t, ws, []string{imApp}, true)
+	assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, 123456)), 1)
+
+	mustSendAuthMessage(t, ws, 12345, "654321")
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10), unauthorizedMessage())
+	assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, 123456)), 0)
+	assertSubscribe(t, ws, []string{imApp}, false)
+}
+
+func TestAuthExpired(t *testing.T) {
+	store := NewInMemeryWSClientStore()
+	authServer := &expiringAuthServer{ttl: time.Millisecond * 50}
+	server := httptest.NewServer(NewGatewayServer(store, authServer))
+	defer server.Close()
+
+	ws := mustConnectAndAuthAndSubscribe(t, server, 123456, "654321", imApp)
+	defer ws.Close()
+	assertSubscribe(t, ws, []string{"match"}, true)
+	assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, 123456)), 1)
+
+	msg := mustReadMessageWithTimeout(t, ws, time.Millisecond*100)
+	assertMessage(t, msg, authExpiredMessage())
+	assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, 123456)), 0)
+	assertWSClientCount(t, len(store.publicWSClientsForApp("match")), 1)
+	assertSubscribe(t, ws, []string{imApp}, false)
+}
+
+func TestAuthRefreshedBeforeExpired(t *testing.T) {
+	store := NewInMemeryWSClientStore()
+	authServer := &expiringAuthServer{ttl: time.Millisecond * 50}
+	server := httptest.NewServer(NewGatewayServer(store, authServer))
+	defer server.Close()
+
+	ws := mustConnectAndAuthAndSubscribe(t, server, 123456, "654321", imApp)
+	defer ws.Close()
+
+	time.Sleep(time.Millisecond * 30)
+	mustSendAuthMessage(t, ws, 123456, refreshedToken)
+	assertHelloMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10), helloMessageForMember(123456))
+
+	_, err := readMessageWithTimeout(ws, time.Millisecond*50)
+	assertError(t, err)
+	assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, 123456)), 1)
+}
+
+func TestCloseOnAuthExpired(t *testing.T) {
+	store := NewInMemeryWSClientStore()
+	authServer := &expiringAuthServer{ttl: time.Millisecond * 50}
+	server := httptest.NewServer(NewGatewayServer(store, authServer, WithCloseOnAuthExpired()))
+	defer server.Close()
+
+	ws := mustConnectAndAuthAndSubscribe(t, server, 123456, "654321", imApp)
+	defer ws.Close()
+
+	msg := mustReadMessageWithTimeout(t, ws, time.Millisecond*100)
+	assertMessage(t, msg, authExpiredMessage())
+
+	_, err := readMessageWithTimeout(ws, time.Millisecond*10)
+	assertError(t, err)
+	time.Sleep(time.Millisecond * 10)
+	assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, 123456)), 0)
+}
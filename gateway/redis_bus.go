@@ -0,0 +1,110 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// DefaultRedisBusChannel default redis channel push messages are published to
+const DefaultRedisBusChannel = "ws-gateway:push"
+
+// RedisBus MessageBus based on redis pub/sub, messages are published to
+// channel "<channel>.<app>.<member id>" and subscribed by pattern
+type RedisBus struct {
+	client  *redis.Client
+	channel string
+}
+
+// NewRedisBus create a new RedisBus publishing push messages under channel
+func NewRedisBus(client *redis.Client, channel string) *RedisBus {
+	if channel == "" {
+		channel = DefaultRedisBusChannel
+	}
+	return &RedisBus{
+		client:  client,
+		channel: channel,
+	}
+}
+
+// Publish publish push message to subscribers of its app and member on all nodes
+func (b *RedisBus) Publish(ctx context.Context, pushMsg *PushMessage) error {
+	data, err := json.Marshal(pushMsg)
+	if err != nil {
+		return err
+	}
+	channel := fmt.Sprintf("%s.%s.%d", b.channel, pushMsg.App, pushMsg.MemberID)
+	return b.client.WithContext(ctx).Publish(channel, data).Err()
+}
+
+// PublishToNodes publish push message to given nodes only
+func (b *RedisBus) PublishToNodes(ctx context.Context, nodes []string, pushMsg *PushMessage) error {
+	data, err := json.Marshal(pushMsg)
+	if err != nil {
+		return err
+	}
+	pipe := b.client.WithContext(ctx).Pipeline()
+	for _, node := range nodes {
+		pipe.Publish(b.nodeChannel(node), data)
+	}
+	_, err = pipe.Exec()
+	return err
+}
+
+// Subscribe deliver push messages published to app and member to handler until ctx is done
+func (b *RedisBus) Subscribe(ctx context.Context, app string, memberID int, handler func(*PushMessage) error) error {
+	appPattern, memberPattern := app, strconv.Itoa(memberID)
+	if app == "" {
+		appPattern = "*"
+	}
+	if memberID == AllMembers {
+		memberPattern = "*"
+	}
+	pattern := b.channel + "." + appPattern + "." + memberPattern
+	return b.subscribe(ctx, b.client.PSubscribe(pattern), pattern, handler)
+}
+
+// SubscribeNode deliver push messages published to node to handler until ctx is done
+func (b *RedisBus) SubscribeNode(ctx context.Context, node string, handler func(*PushMessage) error) error {
+	channel := b.nodeChannel(node)
+	return b.subscribe(ctx, b.client.Subscribe(channel), channel, handler)
+}
+
+func (b *RedisBus) nodeChannel(node string) string {
+	return b.channel + ":node:" + node
+}
+
+func (b *RedisBus) subscribe(ctx context.Context, pubsub *redis.PubSub, channel string, handler func(*PushMessage) error) error {
+	if _, err := pubsub.Receive(); err != nil {
+		pubsub.Close()
+		return fmt.Errorf("subscribe redis channel %s failed: %v", channel, err)
+	}
+
+	messages := pubsub.Channel()
+	go func() {
+		defer pubsub.Close()
+		for {
+			select {
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				var pushMsg PushMessage
+				if err := json.Unmarshal([]byte(msg.Payload), &pushMsg); err != nil {
+					log.Printf("drop bad push message %q: %v", msg.Payload, err)
+					continue
+				}
+				if err := handler(&pushMsg); err != nil {
+					log.Printf("handle push message of app %s failed: %v", pushMsg.App, err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,148 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+)
+
+func newRedisBus(t *testing.T, mr *miniredis.Miniredis) *RedisBus {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	return NewRedisBus(client, "")
+}
+
+func TestClusterPush(t *testing.T) {
+	mr, err := miniredis.Run()
+	assertNoError(t, err)
+	defer mr.Close()
+
+	gateway1 := NewGatewayServer(NewInMemeryWSClientStore(), &FakeAuthServer{}, WithMessageBus(newRedisBus(t, mr)))
+	gateway2 := NewGatewayServer(NewInMemeryWSClientStore(), &FakeAuthServer{}, WithMessageBus(newRedisBus(t, mr)))
+	server1 := httptest.NewServer(gateway1)
+	defer server1.Close()
+	server2 := httptest.NewServer(gateway2)
+	defer server2.Close()
+
+	ws1 := mustConnectAndAuthAndSubscribe(t, server1, 123456, "654321", "match")
+	defer ws1.Close()
+	ws2 := mustConnectAndAuthAndSubscribe(t, server2, 123456, "654321", "match")
+	defer ws2.Close()
+	mustSendSubscribeMessage(t, ws2, imApp)
+	mustReadMessageWithTimeout(t, ws2, time.Millisecond*10)
+
+	t.Run("public push reaches clients on all nodes", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		gateway1.ServeHTTP(response, newPushMessagePostRequest("match", anonymousMemberID, "score"))
+		assertStatusCode(t, response.Code, http.StatusAccepted)
+
+		want := `{"app":"match","member_id":-1,"text":"score"}`
+		assertMessage(t, mustReadMessageWithTimeout(t, ws1, time.Millisecond*100), want)
+		assertMessage(t, mustReadMessageWithTimeout(t, ws2, time.Millisecond*100), want)
+	})
+
+	t.Run("private push reaches member on other node", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		gateway1.ServeHTTP(response, newPushMessagePostRequest(imApp, 123456, "hi"))
+		assertStatusCode(t, response.Code, http.StatusAccepted)
+
+		assertMessage(t, mustReadMessageWithTimeout(t, ws2, time.Millisecond*100), `{"app":"im","member_id":123456,"text":"hi"}`)
+		_, err := readMessageWithTimeout(ws1, time.Millisecond*50)
+		assertError(t, err)
+	})
+}
+
+func TestClusterPublishFailed(t *testing.T) {
+	mr, err := miniredis.Run()
+	assertNoError(t, err)
+
+	gateway := NewGatewayServer(NewInMemeryWSClientStore(), &FakeAuthServer{}, WithMessageBus(newRedisBus(t, mr)))
+	mr.Close()
+
+	response := httptest.NewRecorder()
+	gateway.ServeHTTP(response, newPushMessagePostRequest("match", anonymousMemberID, "score"))
+	assertStatusCode(t, response.Code, http.StatusServiceUnavailable)
+	assertEqual(t, response.Header().Get("Retry-After"), "1")
+}
+
+func TestClusterShutdown(t *testing.T) {
+	mr, err := miniredis.Run()
+	assertNoError(t, err)
+	defer mr.Close()
+
+	gateway := NewGatewayServer(NewInMemeryWSClientStore(), &FakeAuthServer{}, WithMessageBus(newRedisBus(t, mr)))
+	assertNoError(t, gateway.Shutdown(context.Background()))
+
+	response := httptest.NewRecorder()
+	gateway.ServeHTTP(response, newPushMessagePostRequest("match", anonymousMemberID, "score"))
+	assertStatusCode(t, response.Code, http.StatusServiceUnavailable)
+
+	// messages from other nodes are dropped after shutdown
+	assertNoError(t, newRedisBus(t, mr).Publish(context.Background(), &PushMessage{App: "match", MemberID: -1, Text: "late"}))
+	time.Sleep(time.Millisecond * 20)
+}
+
+func TestRedisBusSubscriptions(t *testing.T) {
+	mr, err := miniredis.Run()
+	assertNoError(t, err)
+	defer mr.Close()
+
+	assertBusSubscriptions(t, newRedisBus(t, mr))
+}
+
+func TestClusterPushQueueFull(t *testing.T) {
+	mr, err := miniredis.Run()
+	assertNoError(t, err)
+	defer mr.Close()
+
+	store := newGatedWSStore()
+	defer close(store.gate)
+	gateway := NewGatewayServer(store, &FakeAuthServer{}, WithMessageBus(newRedisBus(t, mr)), WithPushQueue(PushQueueConfig{
+		Size:         1,
+		Policy:       PushQueueBlock,
+		BlockTimeout: time.Second * 5,
+	}))
+
+	assertStatusCode(t, pushTo(gateway, "1").Code, http.StatusAccepted)
+	time.Sleep(time.Millisecond * 20)
+	assertStatusCode(t, pushTo(gateway, "2").Code, http.StatusAccepted)
+	time.Sleep(time.Millisecond * 20)
+	assertEqual(t, counterOf(gateway, "push_queue_depth"), 1)
+
+	response := pushTo(gateway, "3")
+	assertStatusCode(t, response.Code, http.StatusServiceUnavailable)
+	assertEqual(t, response.Header().Get("Retry-After"), "1")
+
+	// message of other node is dropped instead of stalling bus subscriber
+	assertNoError(t, newRedisBus(t, mr).Publish(context.Background(), &PushMessage{App: "match", MemberID: -1, Text: "4"}))
+	time.Sleep(time.Millisecond * 50)
+	assertEqual(t, counterOf(gateway, "push_rejected"), 2)
+}
+
+func TestClusterReplay(t *testing.T) {
+	mr, err := miniredis.Run()
+	assertNoError(t, err)
+	defer mr.Close()
+
+	historyApp := "score-history"
+	registry := NewAppRegistry(AppConfig{Name: historyApp, Visibility: PublicApp, HistorySize: 10})
+	gateway := NewGatewayServer(NewInMemeryWSClientStoreForApps(registry), &FakeAuthServer{},
+		WithMessageBus(newRedisBus(t, mr)), WithAppRegistry(registry))
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	ws := mustConnectAndAuthAndSubscribe(t, server, 123456, "654321", "match")
+	defer ws.Close()
+
+	mustWriteMessage(t, ws, fmt.Sprintf(`{"app":"%s","since":1}`, historyApp))
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*100), sinceUnsupportedMessageForApp(historyApp))
+
+	mustWriteMessage(t, ws, fmt.Sprintf(`{"app":"%s","last":1}`, historyApp))
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*100), subscribeSuccessMessageForApp(historyApp))
+}
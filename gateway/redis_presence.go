@@ -0,0 +1,64 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// DefaultRedisPresencePrefix default key prefix of presence entries
+const DefaultRedisPresencePrefix = "ws-gateway:presence"
+
+// RedisPresence PresenceRegistry based on redis, nodes of a member are kept
+// in a sorted set scored by lease expiry time in milliseconds
+type RedisPresence struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisPresence create a new RedisPresence storing entries under key prefix
+func NewRedisPresence(client *redis.Client, prefix string) *RedisPresence {
+	if prefix == "" {
+		prefix = DefaultRedisPresencePrefix
+	}
+	return &RedisPresence{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func (p *RedisPresence) key(app string, memberID int) string {
+	return fmt.Sprintf("%s:%s:%d", p.prefix, app, memberID)
+}
+
+// Register register or renew lease of node for member
+func (p *RedisPresence) Register(ctx context.Context, app string, memberID int, node string, ttl time.Duration) error {
+	key := p.key(app, memberID)
+	now := time.Now()
+	pipe := p.client.WithContext(ctx).TxPipeline()
+	pipe.ZRemRangeByScore(key, "-inf", strconv.FormatInt(unixMilli(now), 10))
+	pipe.ZAdd(key, &redis.Z{Score: float64(unixMilli(now.Add(ttl))), Member: node})
+	pipe.PExpire(key, ttl)
+	_, err := pipe.Exec()
+	return err
+}
+
+// Unregister remove lease of node for member
+func (p *RedisPresence) Unregister(ctx context.Context, app string, memberID int, node string) error {
+	return p.client.WithContext(ctx).ZRem(p.key(app, memberID), node).Err()
+}
+
+// Nodes returns nodes holding unexpired leases of member
+func (p *RedisPresence) Nodes(ctx context.Context, app string, memberID int) ([]string, error) {
+	return p.client.WithContext(ctx).ZRangeByScore(p.key(app, memberID), &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(unixMilli(time.Now()), 10),
+		Max: "+inf",
+	}).Result()
+}
+
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,109 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// newBenchWSConns connect count clients to wsConns of a test server, each
+// client reads messages and calls received.Done per message
+func newBenchWSConns(b *testing.B, count int, received *sync.WaitGroup) ([]Conn, func()) {
+	b.Helper()
+	wsConns := make(chan *wsConn, count)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			b.Errorf("upgrade failed: %v", err)
+			return
+		}
+		wsConns <- newWSConn(conn, wsConnConfig{
+			sendQueueSize:      defaultSendQueueSize,
+			writeWait:          defaultWriteWait,
+			slowConsumerPolicy: DropOldest,
+		})
+	}))
+
+	conns := make([]Conn, 0, count)
+	clients := make([]*websocket.Conn, 0, count)
+	for i := 0; i < count; i++ {
+		client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		if err != nil {
+			b.Fatalf("dial failed: %v", err)
+		}
+		clients = append(clients, client)
+		conns = append(conns, <-wsConns)
+		go func() {
+			for {
+				if _, _, err := client.ReadMessage(); err != nil {
+					return
+				}
+				received.Done()
+			}
+		}()
+	}
+
+	return conns, func() {
+		for _, conn := range conns {
+			conn.(*wsConn).Close()
+		}
+		for _, client := range clients {
+			client.Close()
+		}
+		server.Close()
+	}
+}
+
+// BenchmarkFanout an op is a push message encoded and delivered through send
+// queues and sockets to all clients, messages are dispatched in windows of
+// half send queue, so no message is dropped
+func BenchmarkFanout(b *testing.B) {
+	connCount := 100
+	window := defaultSendQueueSize / 2
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) {
+			var received sync.WaitGroup
+			conns, closeConns := newBenchWSConns(b, connCount, &received)
+			defer closeConns()
+			f := newFanout(workers, &slowConsumerStats{})
+			defer f.close()
+
+			b.ResetTimer()
+			for sent := 0; sent < b.N; sent += window {
+				n := window
+				if b.N-sent < n {
+					n = b.N - sent
+				}
+				received.Add(n * connCount)
+				for i := 0; i < n; i++ {
+					f.dispatch(&PushMessage{App: "match", MemberID: anonymousMemberID, Text: `{"hello":"world"}`}, conns)
+				}
+				received.Wait()
+			}
+			b.StopTimer()
+		})
+	}
+}
+
+func BenchmarkWSClientStoreChurn(b *testing.B) {
+	appCount := 1000
+	store := NewInMemeryWSClientStore()
+	for i := 0; i < appCount; i++ {
+		store.save(fmt.Sprintf("app-%d", i), anonymousMemberID, newStubWSConn("idle"))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ws := newStubWSConn("churn")
+		for j := 0; j < 3; j++ {
+			store.save(fmt.Sprintf("app-%d", (i+j)%appCount), anonymousMemberID, ws)
+		}
+		store.delete(anonymousMemberID, ws)
+	}
+}
@@ -0,0 +1,49 @@
+package gateway
+
+import (
+	"sync"
+	"time"
+)
+
+// session state of a websocket connection after auth
+type session struct {
+	ws       *wsConn
+	registry *AppRegistry
+
+	mu          sync.Mutex
+	identity    Identity
+	apps        map[string]bool
+	expiryTimer *time.Timer
+	closed      bool
+}
+
+func newSession(ws *wsConn, identity Identity, registry *AppRegistry) *session {
+	return &session{
+		ws:       ws,
+		registry: registry,
+		identity: identity,
+		apps:     make(map[string]bool),
+	}
+}
+
+// canSubscribe check if app can be subscribed by current identity, must hold s.mu
+func (s *session) canSubscribe(app string) bool {
+	if !isAppAllowed(s.identity.Apps, app) {
+		return false
+	}
+	if (s.registry.isPrivate(app) || s.registry.isAuthenticated(app)) && !isValidMemberID(s.identity.MemberID) {
+		return false
+	}
+	return true
+}
+
+// close stop expiry timer, returns member id of session
+func (s *session) close() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.expiryTimer != nil {
+		s.expiryTimer.Stop()
+	}
+	return s.identity.MemberID
+}
@@ -0,0 +1,164 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultDrainWindow    = time.Second * 10
+	defaultDrainBatchSize = 100
+	goingAwayReason       = "server going away"
+	goingAwayFormat       = `{"code":1001,"message":"server going away","reconnect_after_ms":%d}`
+)
+
+// ErrServerShutdown returned by Shutdown when server is already shutting down
+var ErrServerShutdown = errors.New("gateway server is shutting down")
+
+func goingAwayText(reconnectAfter time.Duration) string {
+	return fmt.Sprintf(goingAwayFormat, int64(reconnectAfter/time.Millisecond))
+}
+
+// acceptConn register connection of server, returns false when server is shutting down
+func (g *Server) acceptConn(ws *wsConn) bool {
+	g.shutdownMu.RLock()
+	defer g.shutdownMu.RUnlock()
+	if g.shuttingDown {
+		return false
+	}
+	g.conns.Store(ws, struct{}{})
+	return true
+}
+
+func (g *Server) releaseConn(ws *wsConn) {
+	g.conns.Delete(ws)
+}
+
+// enqueuePush queue push message unless server is shutting down, wait
+// for queue space as push queue policy says only when wait is set
+func (g *Server) enqueuePush(pushMsg *PushMessage, wait bool) (queued bool, shuttingDown bool) {
+	g.shutdownMu.RLock()
+	defer g.shutdownMu.RUnlock()
+	if g.shuttingDown {
+		return false, true
+	}
+	if !wait {
+		return g.pushQueue.offer(pushMsg), false
+	}
+	return g.pushQueue.enqueue(pushMsg), false
+}
+
+func (g *Server) isShuttingDown() bool {
+	g.shutdownMu.RLock()
+	defer g.shutdownMu.RUnlock()
+	return g.shuttingDown
+}
+
+func (g *Server) rejectShuttingDown(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", g.pushQueue.retryAfter())
+	http.Error(w, goingAwayReason, http.StatusServiceUnavailable)
+}
+
+// Shutdown stop accepting new connections and pushes, drain push queue,
+// then send going away message to clients and close connections with close
+// code 1001 in batches over drain window. Connections left when ctx is done
+// are closed at once. Server unsubscribes message bus when Shutdown returns.
+func (g *Server) Shutdown(ctx context.Context) error {
+	g.shutdownMu.Lock()
+	if g.shuttingDown {
+		g.shutdownMu.Unlock()
+		return ErrServerShutdown
+	}
+	g.shuttingDown = true
+	g.shutdownMu.Unlock()
+
+	defer g.unsubscribeBus()
+	if g.presence != nil {
+		g.presence.close()
+	}
+
+	if err := g.pushQueue.drain(ctx); err != nil {
+		log.Printf("drain push queue failed: %v", err)
+		g.closeConns(g.activeConns())
+		return err
+	}
+
+	select {
+	case <-g.pushLoopDone:
+	case <-ctx.Done():
+		g.closeConns(g.activeConns())
+		return ctx.Err()
+	}
+
+	return g.closeConnsInBatches(ctx, g.activeConns())
+}
+
+func (g *Server) activeConns() []*wsConn {
+	var conns []*wsConn
+	g.conns.Range(func(k, v interface{}) bool {
+		conns = append(conns, k.(*wsConn))
+		return true
+	})
+	return conns
+}
+
+func (g *Server) closeConnsInBatches(ctx context.Context, conns []*wsConn) error {
+	if len(conns) == 0 {
+		return nil
+	}
+
+	batchCount := (len(conns) + g.drainBatchSize - 1) / g.drainBatchSize
+	interval := g.drainWindow / time.Duration(batchCount)
+
+	var wg sync.WaitGroup
+	for i := 0; i < len(conns); i += g.drainBatchSize {
+		if i > 0 {
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				g.closeConns(conns[i:])
+				wg.Wait()
+				return ctx.Err()
+			}
+		}
+
+		end := i + g.drainBatchSize
+		if end > len(conns) {
+			end = len(conns)
+		}
+		for _, ws := range conns[i:end] {
+			wg.Add(1)
+			go func(ws *wsConn) {
+				defer wg.Done()
+				g.goAway(ws)
+			}(ws)
+		}
+	}
+	wg.Wait()
+	return nil
+}
+
+// goAway send going away message with a random reconnect hint within drain
+// window and close connection with code 1001
+func (g *Server) goAway(ws *wsConn) {
+	reconnectAfter := time.Duration(0)
+	if g.drainWindow > 0 {
+		reconnectAfter = time.Duration(rand.Int63n(int64(g.drainWindow)))
+	}
+	ws.reply("", goingAwayText(reconnectAfter))
+	ws.closeWithCode(websocket.CloseGoingAway, goingAwayReason)
+}
+
+func (g *Server) closeConns(conns []*wsConn) {
+	for _, ws := range conns {
+		ws.closeWithCode(websocket.CloseGoingAway, goingAwayReason)
+	}
+}
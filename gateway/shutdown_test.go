@@ -0,0 +1,80 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestShutdown(t *testing.T) {
+	store := NewInMemeryWSClientStore()
+	gateway := NewGatewayServer(store, &FakeAuthServer{}, WithDrainWindow(time.Millisecond*100, 2))
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	var clients []*websocket.Conn
+	for i := 0; i < 5; i++ {
+		ws := mustConnectAndAuthAndSubscribe(t, server, anonymousMemberID, "", "match")
+		defer ws.Close()
+		clients = append(clients, ws)
+	}
+
+	msgText := `{"hello":"world"}`
+	assertStatusCode(t, pushTo(gateway, msgText).Code, http.StatusAccepted)
+
+	start := time.Now()
+	assertNoError(t, gateway.Shutdown(context.Background()))
+	assertEqual(t, time.Since(start) >= time.Millisecond*60, true)
+	assertEqual(t, gateway.Shutdown(context.Background()), ErrServerShutdown)
+
+	for _, ws := range clients {
+		assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*100), string(pushMessageJSONFor("match", anonymousMemberID, msgText)))
+
+		var goingAway PushMessage
+		assertNoError(t, json.Unmarshal([]byte(mustReadMessageWithTimeout(t, ws, time.Millisecond*100)), &goingAway))
+		var reply struct {
+			Code           int `json:"code"`
+			ReconnectAfter int `json:"reconnect_after_ms"`
+		}
+		assertNoError(t, json.Unmarshal([]byte(goingAway.Text), &reply))
+		assertEqual(t, reply.Code, websocket.CloseGoingAway)
+		assertEqual(t, reply.ReconnectAfter < 100, true)
+
+		_, err := readMessageWithTimeout(ws, time.Millisecond*100)
+		assertEqual(t, websocket.IsCloseError(err, websocket.CloseGoingAway), true)
+	}
+
+	t.Run("reject push and upgrade after shutdown", func(t *testing.T) {
+		response := pushTo(gateway, msgText)
+		assertStatusCode(t, response.Code, http.StatusServiceUnavailable)
+		assertEqual(t, response.Header().Get("Retry-After"), "1")
+
+		_, response2, err := dialWithRequest(server, "", nil)
+		assertError(t, err)
+		assertStatusCode(t, response2.StatusCode, http.StatusServiceUnavailable)
+	})
+}
+
+func TestShutdownContextDone(t *testing.T) {
+	store := NewInMemeryWSClientStore()
+	gateway := NewGatewayServer(store, &FakeAuthServer{}, WithDrainWindow(time.Second*10, 1))
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	ws1 := mustConnectAndAuthAndSubscribe(t, server, anonymousMemberID, "", "match")
+	defer ws1.Close()
+	ws2 := mustConnectAndAuthAndSubscribe(t, server, anonymousMemberID, "", "match")
+	defer ws2.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	assertEqual(t, gateway.Shutdown(ctx), context.DeadlineExceeded)
+
+	time.Sleep(time.Millisecond * 10)
+	assertWSClientCount(t, len(store.publicWSClientsForApp("match")), 0)
+}
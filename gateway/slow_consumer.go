@@ -0,0 +1,123 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// SlowConsumerPolicy decides what to do when send queue of a connection is full
+type SlowConsumerPolicy string
+
+const (
+	// DropOldest drop oldest queued message to make room for new message
+	DropOldest SlowConsumerPolicy = "drop-oldest"
+	// DropNewest drop new message
+	DropNewest SlowConsumerPolicy = "drop-newest"
+	// Disconnect close connection with SlowConsumerCloseCode
+	Disconnect SlowConsumerPolicy = "disconnect"
+)
+
+// SlowConsumerCloseCode websocket close code of connection kicked as slow consumer
+const SlowConsumerCloseCode = 4000
+
+const slowConsumerCloseReason = "slow consumer"
+
+var (
+	errMessageDropped = errors.New("send queue is full, message dropped")
+	errSlowConsumer   = errors.New("send queue is full, slow consumer kicked")
+)
+
+// ParseSlowConsumerPolicy parse policy name
+func ParseSlowConsumerPolicy(name string) (SlowConsumerPolicy, error) {
+	policy := SlowConsumerPolicy(name)
+	switch policy {
+	case DropOldest, DropNewest, Disconnect:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown slow consumer policy %q", name)
+}
+
+// sendQueueFull apply slow consumer policy when send queue is full
+func (ws *wsConn) sendQueueFull(msg []byte) error {
+	switch ws.slowConsumerPolicy {
+	case DropNewest:
+		return errMessageDropped
+	case Disconnect:
+		kicked := false
+		ws.kickOnce.Do(func() {
+			kicked = true
+			go ws.kick()
+		})
+		if kicked {
+			return errSlowConsumer
+		}
+		return websocket.ErrCloseSent
+	}
+
+	for {
+		select {
+		case <-ws.outbound:
+		default:
+		}
+
+		select {
+		case ws.outbound <- msg:
+			return errMessageDropped
+		case <-ws.done:
+			return websocket.ErrCloseSent
+		default:
+		}
+	}
+}
+
+// kick send close message and close connection, reader and writer
+// goroutines fail and connection is removed from store
+func (ws *wsConn) kick() {
+	closeMsg := websocket.FormatCloseMessage(SlowConsumerCloseCode, slowConsumerCloseReason)
+	ws.conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(ws.writeWait))
+	ws.conn.Close()
+}
+
+// slowConsumerStats per app counters of dropped messages and kicked slow consumers
+type slowConsumerStats struct {
+	dropped sync.Map
+	kicked  sync.Map
+}
+
+func (s *slowConsumerStats) add(counters *sync.Map, app string) {
+	v, _ := counters.LoadOrStore(app, new(int64))
+	atomic.AddInt64(v.(*int64), 1)
+}
+
+// record count write error of fan-out to app
+func (s *slowConsumerStats) record(app string, err error) {
+	switch err {
+	case errMessageDropped:
+		s.add(&s.dropped, app)
+	case errSlowConsumer:
+		s.add(&s.kicked, app)
+	}
+}
+
+func (s *slowConsumerStats) counters() []wsCount {
+	var result []wsCount
+	collect := func(counters *sync.Map, suffix string) {
+		counters.Range(func(k, v interface{}) bool {
+			name := fmt.Sprintf("%s.%s", k.(string), suffix)
+			result = append(result, wsCount{name, int(atomic.LoadInt64(v.(*int64)))})
+			return true
+		})
+	}
+	collect(&s.dropped, "dropped_messages")
+	collect(&s.kicked, "slow_consumer_kicks")
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].name < result[j].name
+	})
+	return result
+}
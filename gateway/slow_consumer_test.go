@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newStalledWSConn create a wsConn whose writer goroutine is not started,
+// so send queue is never drained
+func newStalledWSConn(conn *websocket.Conn, policy SlowConsumerPolicy) *wsConn {
+	return &wsConn{
+		conn:               conn,
+		closed:             make(chan struct{}),
+		done:               make(chan struct{}),
+		outbound:           make(chan []byte, 2),
+		writerDone:         make(chan struct{}),
+		writeWait:          defaultWriteWait,
+		slowConsumerPolicy: policy,
+	}
+}
+
+func TestSlowConsumerPolicy(t *testing.T) {
+	queued := func(ws *wsConn) []string {
+		var result []string
+		for len(ws.outbound) > 0 {
+			result = append(result, string(<-ws.outbound))
+		}
+		return result
+	}
+
+	t.Run("drop oldest", func(t *testing.T) {
+		ws := newStalledWSConn(nil, DropOldest)
+		assertNoError(t, ws.WriteMessage([]byte("1")))
+		assertNoError(t, ws.WriteMessage([]byte("2")))
+		assertEqual(t, ws.WriteMessage([]byte("3")), errMessageDropped)
+		assertEqual(t, queued(ws), []string{"2", "3"})
+	})
+
+	t.Run("drop newest", func(t *testing.T) {
+		ws := newStalledWSConn(nil, DropNewest)
+		assertNoError(t, ws.WriteMessage([]byte("1")))
+		assertNoError(t, ws.WriteMessage([]byte("2")))
+		assertEqual(t, ws.WriteMessage([]byte("3")), errMessageDropped)
+		assertEqual(t, queued(ws), []string{"1", "2"})
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		conns := make(chan *websocket.Conn, 1)
+		upgrader := websocket.Upgrader{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			assertNoError(t, err)
+			conns <- conn
+		}))
+		defer server.Close()
+
+		client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		assertNoError(t, err)
+		defer client.Close()
+
+		ws := newStalledWSConn(<-conns, Disconnect)
+		assertNoError(t, ws.WriteMessage([]byte("1")))
+		assertNoError(t, ws.WriteMessage([]byte("2")))
+		assertEqual(t, ws.WriteMessage([]byte("3")), errSlowConsumer)
+		assertEqual(t, ws.WriteMessage([]byte("4")), websocket.ErrCloseSent)
+
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		_, _, err = client.ReadMessage()
+		assertEqual(t, websocket.IsCloseError(err, SlowConsumerCloseCode), true)
+	})
+}
+
+func TestSlowConsumerStats(t *testing.T) {
+	store := NewInMemeryWSClientStore()
+	gateway := NewGatewayServer(store, &FakeAuthServer{})
+
+	gateway.slowConsumers.record("match", errMessageDropped)
+	gateway.slowConsumers.record("match", errMessageDropped)
+	gateway.slowConsumers.record("match", errSlowConsumer)
+	gateway.slowConsumers.record("chat", nil)
+
+	response := httptest.NewRecorder()
+	NewStatServer(store, gateway).ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/stat", nil))
+	assertEqual(t, response.Body.String(), "heartbeat_timeouts 0\npush_queue_depth 0\npush_rejected 0\nmatch.dropped_messages 2\nmatch.slow_consumer_kicks 1\n")
+}
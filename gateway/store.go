@@ -0,0 +1,41 @@
+package gateway
+
+import "context"
+
+// Store stores websocket connections subscribed to apps. Implement it to
+// keep connections in another backend, gatewaytest.RunStoreSuite checks an
+// implementation follows semantics below:
+//
+//   - visibility of apps comes from AppRegistry the store is created with,
+//     which should be the registry of server using the store
+//   - connections of public and authenticated app are stored under member
+//     id 0, memberID passed to Save, Delete and DeleteForApp is ignored for them
+//   - connections of private app with invalid member id are not stored
+//   - connections are told apart by ID, saving a connection twice stores it once
+//   - Delete removes connection from all apps it subscribed
+type Store interface {
+	StatStore
+
+	Save(ctx context.Context, app string, memberID int, ws Conn) error
+	Delete(ctx context.Context, memberID int, ws Conn) error
+	DeleteForApp(ctx context.Context, app string, memberID int, ws Conn) error
+	PublicConns(ctx context.Context, app string) ([]Conn, error)
+	PrivateConns(ctx context.Context, app string, memberID int) ([]Conn, error)
+	// Subscriptions returns sorted apps subscribed by connection connID
+	Subscriptions(ctx context.Context, connID string) ([]string, error)
+	// Apps returns apps connections ever subscribed
+	Apps(ctx context.Context) ([]string, error)
+}
+
+// StatStore source of connection counts shown by StatServer
+type StatStore interface {
+	// AppsConnCount returns connection count of each app, private apps
+	// count members instead of connections
+	AppsConnCount(ctx context.Context) ([]AppConnCount, error)
+}
+
+// AppConnCount connection count of app
+type AppConnCount struct {
+	App   string
+	Count int
+}
@@ -1,28 +1,110 @@
 package gateway
 
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
 const (
 	imApp = "im"
 )
 
+func missingAuthMessage() string {
+	return wrapGatewayResponseMessage(missingAuthMessageString)
+}
+
+func unauthorizedMessage() string {
+	return wrapGatewayResponseMessage(unauthorizedMessageString)
+}
+
+func authUnavailableMessage() string {
+	return wrapGatewayResponseMessage(authUnavailableMessageString)
+}
+
+func authExpiredMessage() string {
+	return wrapGatewayResponseMessage(authExpiredMessageString)
+}
+
+func badSubscribeMessage() string {
+	return wrapGatewayResponseMessage(badSubscribeMessageString)
+}
+
+func helloStrangerMessage() string {
+	return wrapGatewayResponseMessage(helloStrangerText(""))
+}
+
+func helloMessageForMember(memberID int) string {
+	return wrapGatewayResponseMessage(helloMemberText(memberID, ""))
+}
+
+// wrapGatewayRawJSONReply wrap gateway response message as reply of client
+// request id in raw JSON mode
+func wrapGatewayRawJSONReply(id string, message string) string {
+	return string(mustEncodePushMessage(gatewayReply(id, message), true))
+}
+
+func subscribeSuccessMessageForApp(app string) string {
+	return wrapGatewayResponseMessage(subscribeSuccessText(app))
+}
+
+func subscribeForbiddenMessageForApp(app string) string {
+	return wrapGatewayResponseMessage(subscribeForbiddenText(app))
+}
+
+func sinceUnsupportedMessageForApp(app string) string {
+	return wrapGatewayResponseMessage(sinceUnsupportedText(app))
+}
+
+func unsubscribeSuccessMessageForApp(app string) string {
+	return wrapGatewayResponseMessage(unsubscribeSuccessText(app))
+}
+
+func unsubscribeNotSubscribedMessageForApp(app string) string {
+	return wrapGatewayResponseMessage(unsubscribeNotSubscribedText(app))
+}
+
+func unsubscribeFailedMessageForApp(app string) string {
+	return wrapGatewayResponseMessage(unsubscribeFailedText(app))
+}
+
+func unknownActionMessage(action string) string {
+	return wrapGatewayResponseMessage(unknownActionText(action))
+}
+
 // StubWSConn implements Conn for testing purpose
 type StubWSConn struct {
+	id     string
 	addr   string
+	mu     sync.Mutex
 	buffer [][]byte
 }
 
 func newStubWSConn(addr string) *StubWSConn {
 	return &StubWSConn{
+		id:     newConnID(),
 		addr:   addr,
 		buffer: make([][]byte, 0),
 	}
 }
 
 func (s *StubWSConn) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.buffer = make([][]byte, 0)
 }
 
+// messages returns copy of buffer
+func (s *StubWSConn) messages() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.buffer...)
+}
+
 // ReadMessage returns message from buffer
 func (s *StubWSConn) ReadMessage() (msg []byte, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	result := s.buffer[0]
 	s.buffer = s.buffer[1:]
 	return result, nil
@@ -30,28 +112,55 @@ func (s *StubWSConn) ReadMessage() (msg []byte, err error) {
 
 // WriteMessage writes message to buffer
 func (s *StubWSConn) WriteMessage(msg []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.buffer = append(s.buffer, msg)
 	return nil
 }
 
+// ID returns connection id
+func (s *StubWSConn) ID() string {
+	return s.id
+}
+
 // RemoteAddr returns addr
 func (s *StubWSConn) RemoteAddr() string {
 	return s.addr
 }
 
-// StubWSStore implements wsStore for testing purpose
+// StubWSStore implements Store for testing purpose
 type StubWSStore struct {
+	mu                                 sync.Mutex
+	registry                           *AppRegistry
 	wsClients                          []Conn
-	imClient                           map[int][]Conn
+	privateClients                     map[string]map[int][]Conn
 	matchClient                        []Conn
+	connApps                           map[string]map[string]bool
 	publicWSClientsForAppWasCalled     bool
 	privateWSClientsForMemberWasCalled bool
 }
 
+func newStubWSStore() *StubWSStore {
+	return &StubWSStore{
+		registry:       DefaultAppRegistry(),
+		privateClients: make(map[string]map[int][]Conn),
+		connApps:       make(map[string]map[string]bool),
+	}
+}
+
 func (s *StubWSStore) save(app string, memberID int, ws Conn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.wsClients = append(s.wsClients, ws)
-	if isPrivateApp(app) && isValidMemberID(memberID) {
-		s.imClient[memberID] = append(s.imClient[memberID], ws)
+	if s.connApps[ws.ID()] == nil {
+		s.connApps[ws.ID()] = make(map[string]bool)
+	}
+	s.connApps[ws.ID()][app] = true
+	if s.registry.isPrivate(app) && isValidMemberID(memberID) {
+		if s.privateClients[app] == nil {
+			s.privateClients[app] = make(map[int][]Conn)
+		}
+		s.privateClients[app][memberID] = append(s.privateClients[app][memberID], ws)
 	}
 
 	if app == "match" {
@@ -62,13 +171,18 @@ func (s *StubWSStore) save(app string, memberID int, ws Conn) error {
 }
 
 func (s *StubWSStore) delete(memberID int, ws Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.connApps, ws.ID())
 	if isValidMemberID(memberID) {
-		delete(s.imClient, memberID)
+		for _, memberClients := range s.privateClients {
+			delete(memberClients, memberID)
+		}
 	}
 
 	foundIndex := -1
 	for i, c := range s.matchClient {
-		if c.RemoteAddr() == ws.RemoteAddr() {
+		if c.ID() == ws.ID() {
 			foundIndex = i
 			break
 		}
@@ -82,23 +196,70 @@ func (s *StubWSStore) delete(memberID int, ws Conn) {
 	}
 }
 
+func (s *StubWSStore) deleteForApp(app string, memberID int, ws Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.connApps[ws.ID()], app)
+	if s.registry.isPrivate(app) {
+		delete(s.privateClients[app], memberID)
+		return
+	}
+
+	if app == "match" {
+		s.matchClient = removeConn(s.matchClient, ws)
+	}
+}
+
+func removeConn(conns []Conn, ws Conn) []Conn {
+	left := make([]Conn, 0)
+	for _, c := range conns {
+		if c.ID() != ws.ID() {
+			left = append(left, c)
+		}
+	}
+	return left
+}
+
 func (s *StubWSStore) publicWSClientsForApp(app string) []Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.publicWSClientsForAppWasCalled = true
 	if app == "match" {
-		return s.matchClient
+		return append([]Conn(nil), s.matchClient...)
 	}
 	return nil
 }
 
-func (s *StubWSStore) privateWSClientsForMember(memberID int) []Conn {
+func (s *StubWSStore) privateWSClientsForMember(app string, memberID int) []Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.privateWSClientsForMemberWasCalled = true
-	return s.imClient[memberID]
+	return append([]Conn(nil), s.privateClients[app][memberID]...)
 }
 
-func (s *StubWSStore) appsWSClientCount() []wsCount {
-	var result []wsCount
-	result = append(result, wsCount{"im", len(s.imClient)})
-	result = append(result, wsCount{"match", len(s.matchClient)})
+func (s *StubWSStore) subscriptions(connID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var apps []string
+	for app := range s.connApps[connID] {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+	return apps
+}
+
+func (s *StubWSStore) wasCalled() (publicWSClientsForApp, privateWSClientsForMember bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.publicWSClientsForAppWasCalled, s.privateWSClientsForMemberWasCalled
+}
+
+func (s *StubWSStore) appsWSClientCount() []AppConnCount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []AppConnCount
+	result = append(result, AppConnCount{"im", len(s.privateClients[imApp])})
+	result = append(result, AppConnCount{"match", len(s.matchClient)})
 	return result
 }
 
@@ -106,6 +267,48 @@ func (s *StubWSStore) apps() []string {
 	return []string{"match", "im"}
 }
 
+// Save implements Store
+func (s *StubWSStore) Save(ctx context.Context, app string, memberID int, ws Conn) error {
+	return s.save(app, memberID, ws)
+}
+
+// Delete implements Store
+func (s *StubWSStore) Delete(ctx context.Context, memberID int, ws Conn) error {
+	s.delete(memberID, ws)
+	return nil
+}
+
+// DeleteForApp implements Store
+func (s *StubWSStore) DeleteForApp(ctx context.Context, app string, memberID int, ws Conn) error {
+	s.deleteForApp(app, memberID, ws)
+	return nil
+}
+
+// PublicConns implements Store
+func (s *StubWSStore) PublicConns(ctx context.Context, app string) ([]Conn, error) {
+	return s.publicWSClientsForApp(app), nil
+}
+
+// PrivateConns implements Store
+func (s *StubWSStore) PrivateConns(ctx context.Context, app string, memberID int) ([]Conn, error) {
+	return s.privateWSClientsForMember(app, memberID), nil
+}
+
+// Subscriptions implements Store
+func (s *StubWSStore) Subscriptions(ctx context.Context, connID string) ([]string, error) {
+	return s.subscriptions(connID), nil
+}
+
+// Apps implements Store
+func (s *StubWSStore) Apps(ctx context.Context) ([]string, error) {
+	return s.apps(), nil
+}
+
+// AppsConnCount implements StatStore
+func (s *StubWSStore) AppsConnCount(ctx context.Context) ([]AppConnCount, error) {
+	return s.appsWSClientCount(), nil
+}
+
 // FakeAuthServer implements AuthServer for testing purpose
 type FakeAuthServer struct{}
 
@@ -116,3 +319,90 @@ func (s *FakeAuthServer) Auth(memberID int, token string) bool {
 	}
 	return true
 }
+
+// FakeKafkaBroker in memory kafka broker for testing purpose, each topic has
+// a single partition and committed offsets are tracked per consumer group
+type FakeKafkaBroker struct {
+	mu        sync.Mutex
+	topics    map[string][]KafkaRecord
+	committed map[string]int64
+	produced  chan struct{}
+}
+
+// NewFakeKafkaBroker create a new FakeKafkaBroker
+func NewFakeKafkaBroker() *FakeKafkaBroker {
+	return &FakeKafkaBroker{
+		topics:    make(map[string][]KafkaRecord),
+		committed: make(map[string]int64),
+		produced:  make(chan struct{}),
+	}
+}
+
+// Produce append record to topic and returns its offset
+func (b *FakeKafkaBroker) Produce(topic string, key, value []byte) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	offset := int64(len(b.topics[topic]))
+	b.topics[topic] = append(b.topics[topic], KafkaRecord{Topic: topic, Offset: offset, Key: key, Value: value})
+	close(b.produced)
+	b.produced = make(chan struct{})
+	return offset
+}
+
+// Committed returns offset consumer group consumes topic from
+func (b *FakeKafkaBroker) Committed(groupID, topic string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.committed[groupID+"/"+topic]
+}
+
+// NewReader create a reader of topic in consumer group groupID, reader
+// starts from committed offset of group
+func (b *FakeKafkaBroker) NewReader(groupID, topic string) KafkaReader {
+	return &fakeKafkaReader{
+		broker: b,
+		group:  groupID + "/" + topic,
+		topic:  topic,
+		offset: b.Committed(groupID, topic),
+	}
+}
+
+type fakeKafkaReader struct {
+	broker *FakeKafkaBroker
+	group  string
+	topic  string
+	offset int64
+}
+
+func (r *fakeKafkaReader) FetchRecord(ctx context.Context) (KafkaRecord, error) {
+	for {
+		r.broker.mu.Lock()
+		records, produced := r.broker.topics[r.topic], r.broker.produced
+		r.broker.mu.Unlock()
+
+		if r.offset < int64(len(records)) {
+			r.offset++
+			return records[r.offset-1], nil
+		}
+		select {
+		case <-produced:
+		case <-ctx.Done():
+			return KafkaRecord{}, ctx.Err()
+		}
+	}
+}
+
+func (r *fakeKafkaReader) CommitRecords(ctx context.Context, records ...KafkaRecord) error {
+	r.broker.mu.Lock()
+	defer r.broker.mu.Unlock()
+	for _, record := range records {
+		if record.Offset+1 > r.broker.committed[r.group] {
+			r.broker.committed[r.group] = record.Offset + 1
+		}
+	}
+	return nil
+}
+
+func (r *fakeKafkaReader) Close() error {
+	return nil
+}
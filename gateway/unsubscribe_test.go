@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestUnsubscribe(t *testing.T) {
+	store := NewInMemeryWSClientStore()
+	gateway := NewGatewayServer(store, &FakeAuthServer{})
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	memberID := 123456
+	ws := mustConnectAndAuthAndSubscribe(t, server, memberID, "654321", imApp)
+	defer ws.Close()
+	assertSubscribe(t, ws, []string{"match", "chat"}, true)
+
+	mustSendUnsubscribeMessage(t, ws, "match")
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10), unsubscribeSuccessMessageForApp("match"))
+	assertWSClientCount(t, len(store.publicWSClientsForApp("match")), 0)
+	assertWSClientCount(t, len(store.publicWSClientsForApp("chat")), 1)
+	assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, memberID)), 1)
+
+	mustSendUnsubscribeMessage(t, ws, imApp)
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10), unsubscribeSuccessMessageForApp(imApp))
+	assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, memberID)), 0)
+
+	mustSendUnsubscribeMessage(t, ws, "match")
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10), unsubscribeNotSubscribedMessageForApp("match"))
+
+	response := httptest.NewRecorder()
+	gateway.ServeHTTP(response, newPushMessagePostRequest("match", anonymousMemberID, `{"hello":"world"}`))
+	_, err := readMessageWithTimeout(ws, time.Millisecond*10)
+	assertError(t, err)
+}
+
+// failingDeleteStore fails to delete connections from apps
+type failingDeleteStore struct {
+	*InMemeryWSClientStore
+}
+
+func (s *failingDeleteStore) DeleteForApp(ctx context.Context, app string, memberID int, ws Conn) error {
+	return errors.New("store unavailable")
+}
+
+func TestUnsubscribeStoreFailed(t *testing.T) {
+	store := &failingDeleteStore{NewInMemeryWSClientStore()}
+	gateway := NewGatewayServer(store, &FakeAuthServer{})
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	ws := mustConnectAndAuthAndSubscribe(t, server, 123456, "654321", "match")
+	defer ws.Close()
+
+	for i := 0; i < 2; i++ {
+		mustSendUnsubscribeMessage(t, ws, "match")
+		assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10), unsubscribeFailedMessageForApp("match"))
+	}
+
+	mustWriteMessage(t, ws, `{"action":"list"}`)
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10), wrapGatewayResponseMessage(subscriptionsText([]string{"match"})))
+	response := httptest.NewRecorder()
+	gateway.ServeHTTP(response, newPushMessagePostRequest("match", anonymousMemberID, "still"))
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*100), `{"app":"match","member_id":-1,"text":"still"}`)
+}
+
+func TestUnknownAction(t *testing.T) {
+	server, _ := newServer()
+	defer server.Close()
+
+	ws, _ := mustConnectTo(t, server)
+	defer ws.Close()
+	mustSendAuthMessage(t, ws, anonymousMemberID, "")
+	mustReadMessageWithTimeout(t, ws, time.Millisecond*10)
+
+	mustWriteMessage(t, ws, `{"action":"publish","app":"match"}`)
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10), unknownActionMessage("publish"))
+}
+
+func mustSendUnsubscribeMessage(t *testing.T, ws *websocket.Conn, app string) {
+	unsubscribeMsg := fmt.Sprintf(`{"action": "unsubscribe", "app": "%s"}`, app)
+	mustWriteMessage(t, ws, unsubscribeMsg)
+}
@@ -0,0 +1,139 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestAuthDuringUpgrade(t *testing.T) {
+	server, store := newServer()
+	defer server.Close()
+
+	tests := []struct {
+		description string
+		query       string
+		header      http.Header
+	}{
+		{"authorization header", "", http.Header{"Authorization": {"Bearer 654321"}, memberIDHeader: {"123456"}}},
+		{"auth cookie", "?member_id=123456", http.Header{"Cookie": {defaultAuthCookieName + "=654321"}}},
+		{"query token", "?member_id=123456&token=654321", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			ws, response, err := dialWithRequest(server, tt.query, tt.header)
+			assertNoError(t, err)
+			defer ws.Close()
+
+			assertStatusCode(t, response.StatusCode, http.StatusSwitchingProtocols)
+			msg := mustReadMessageWithTimeout(t, ws, time.Millisecond*10)
+			assertHelloMessage(t, msg, helloMessageForMember(123456))
+
+			mustSendSubscribeMessage(t, ws, imApp)
+			msg = mustReadMessageWithTimeout(t, ws, time.Millisecond*10)
+			assertMessage(t, msg, subscribeSuccessMessageForApp(imApp))
+			assertWSClientCount(t, len(store.privateWSClientsForMember(imApp, 123456)), 1)
+		})
+	}
+}
+
+func TestAuthDuringUpgradeFailed(t *testing.T) {
+	server, _ := newServer()
+	defer server.Close()
+
+	tests := []struct {
+		description string
+		query       string
+		header      http.Header
+	}{
+		{"unauthorized member", "?member_id=12345&token=654321", nil},
+		{"missing member id", "", http.Header{"Authorization": {"Bearer 654321"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			_, response, err := dialWithRequest(server, tt.query, tt.header)
+			assertError(t, err)
+			assertStatusCode(t, response.StatusCode, http.StatusUnauthorized)
+		})
+	}
+}
+
+func TestAuthDuringUpgradeWithCustomCookie(t *testing.T) {
+	gateway := NewGatewayServer(NewInMemeryWSClientStore(), &FakeAuthServer{}, WithAuthCookie("session"))
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	ws, _, err := dialWithRequest(server, "?member_id=123456", http.Header{"Cookie": {"session=654321"}})
+	assertNoError(t, err)
+	defer ws.Close()
+
+	msg := mustReadMessageWithTimeout(t, ws, time.Millisecond*10)
+	assertHelloMessage(t, msg, helloMessageForMember(123456))
+}
+
+func TestAuthCookieOrigin(t *testing.T) {
+	gateway := NewGatewayServer(NewInMemeryWSClientStore(), &FakeAuthServer{}, WithAllowedOrigins("https://app.example.com"))
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	cookie := defaultAuthCookieName + "=654321"
+	tests := []struct {
+		description string
+		header      http.Header
+		ok          bool
+	}{
+		{"cookie from foreign origin", http.Header{"Cookie": {cookie}, "Origin": {"https://evil.example.com"}}, false},
+		{"cookie from allowed origin", http.Header{"Cookie": {cookie}, "Origin": {"https://APP.example.com"}}, true},
+		{"cookie from gateway origin", http.Header{"Cookie": {cookie}, "Origin": {server.URL}}, true},
+		{"cookie without origin", http.Header{"Cookie": {cookie}}, true},
+		{"bearer token from foreign origin", http.Header{"Authorization": {"Bearer 654321"}, "Origin": {"https://evil.example.com"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			ws, response, err := dialWithRequest(server, "?member_id=123456", tt.header)
+			if !tt.ok {
+				assertError(t, err)
+				assertStatusCode(t, response.StatusCode, http.StatusForbidden)
+				return
+			}
+			assertNoError(t, err)
+			defer ws.Close()
+			assertHelloMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*10), helloMessageForMember(123456))
+		})
+	}
+}
+
+type countingAuthServer struct {
+	FakeAuthServer
+	calls int32
+}
+
+func (s *countingAuthServer) Auth(memberID int, token string) bool {
+	atomic.AddInt32(&s.calls, 1)
+	return s.FakeAuthServer.Auth(memberID, token)
+}
+
+func TestAuthCookieForeignOriginNotAuthenticated(t *testing.T) {
+	authServer := &countingAuthServer{}
+	server := httptest.NewServer(NewGatewayServer(NewInMemeryWSClientStore(), authServer))
+	defer server.Close()
+
+	header := http.Header{"Cookie": {defaultAuthCookieName + "=654321"}, "Origin": {"https://evil.example.com"}}
+	_, response, err := dialWithRequest(server, "?member_id=123456", header)
+	assertError(t, err)
+	assertStatusCode(t, response.StatusCode, http.StatusForbidden)
+	assertEqual(t, int(atomic.LoadInt32(&authServer.calls)), 0)
+}
+
+func dialWithRequest(server *httptest.Server, query string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + websocketURLPath + query
+	return websocket.DefaultDialer.Dial(wsURL, header)
+}
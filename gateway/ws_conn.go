@@ -1,26 +1,225 @@
 package gateway
 
-import "github.com/gorilla/websocket"
+import (
+	"errors"
+	"sync"
+	"time"
 
+	"github.com/gorilla/websocket"
+)
+
+var errReadTimeout = errors.New("read message timeout")
+
+const (
+	defaultSendQueueSize = 256
+	defaultWriteWait     = time.Second * 10
+)
+
+// wsConnConfig config of websocket connection
+type wsConnConfig struct {
+	heartbeat          heartbeatConfig
+	sendQueueSize      int
+	writeWait          time.Duration
+	slowConsumerPolicy SlowConsumerPolicy
+}
+
+// wsConn websocket connection, messages are read by reader goroutine and
+// written by writer goroutine, so WriteMessage is safe for concurrent use
 type wsConn struct {
-	conn *websocket.Conn
+	id         string
+	conn       *websocket.Conn
+	remoteAddr string
+
+	messages  chan []byte
+	closed    chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	readErr   error
+
+	outbound   chan []byte
+	writerDone chan struct{}
+	writeErr   error
+	writeWait  time.Duration
+
+	slowConsumerPolicy SlowConsumerPolicy
+	kickOnce           sync.Once
+
+	rawJSONMode bool
+	heartbeat   heartbeatConfig
+}
+
+func newWSConn(conn *websocket.Conn, config wsConnConfig) *wsConn {
+	ws := &wsConn{
+		id:         newConnID(),
+		conn:       conn,
+		remoteAddr: conn.RemoteAddr().String(),
+		messages:   make(chan []byte),
+		closed:     make(chan struct{}),
+		done:       make(chan struct{}),
+		outbound:   make(chan []byte, config.sendQueueSize),
+		writerDone: make(chan struct{}),
+		writeWait:  config.writeWait,
+		heartbeat:  config.heartbeat,
+
+		slowConsumerPolicy: config.slowConsumerPolicy,
+	}
+	if ws.heartbeat.enabled() {
+		ws.extendReadDeadline()
+		conn.SetPongHandler(func(string) error {
+			return ws.extendReadDeadline()
+		})
+		go ws.pingLoop()
+	}
+	go ws.readLoop()
+	go ws.writeLoop()
+	return ws
 }
 
-func newWSConn(conn *websocket.Conn) *wsConn {
-	return &wsConn{
-		conn: conn,
+// readLoop reads messages from websocket connection until read failed,
+// closed channel is closed when connection is broken
+func (ws *wsConn) readLoop() {
+	defer close(ws.closed)
+	for {
+		_, msg, err := ws.conn.ReadMessage()
+		if err != nil {
+			ws.readErr = err
+			return
+		}
+		ws.extendReadDeadline()
+
+		select {
+		case ws.messages <- msg:
+		case <-ws.done:
+			ws.readErr = websocket.ErrCloseSent
+			return
+		}
 	}
 }
 
 func (ws *wsConn) ReadMessage() ([]byte, error) {
-	_, msg, err := ws.conn.ReadMessage()
-	return msg, err
+	select {
+	case msg := <-ws.messages:
+		return msg, nil
+	case <-ws.closed:
+		return nil, ws.readErr
+	}
 }
 
-func (ws *wsConn) WriteMessage(msg []byte) error {
+func (ws *wsConn) readMessageWithTimeout(timeout time.Duration) ([]byte, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case msg := <-ws.messages:
+		return msg, nil
+	case <-ws.closed:
+		return nil, ws.readErr
+	case <-timer.C:
+		return nil, errReadTimeout
+	}
+}
+
+// writeLoop writes queued messages until connection closed, pending messages
+// are flushed before writeLoop returns
+func (ws *wsConn) writeLoop() {
+	defer close(ws.writerDone)
+	for {
+		select {
+		case msg := <-ws.outbound:
+			if err := ws.write(msg); err != nil {
+				ws.writeErr = err
+				ws.conn.Close()
+				return
+			}
+		case <-ws.done:
+			ws.flush()
+			return
+		}
+	}
+}
+
+func (ws *wsConn) flush() {
+	for {
+		select {
+		case msg := <-ws.outbound:
+			if err := ws.write(msg); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (ws *wsConn) write(msg []byte) error {
+	ws.conn.SetWriteDeadline(time.Now().Add(ws.writeWait))
 	return ws.conn.WriteMessage(websocket.TextMessage, msg)
 }
 
+// sendQueueSpace count of messages send queue can take before slow
+// consumer policy applies
+func (ws *wsConn) sendQueueSpace() int {
+	return cap(ws.outbound) - len(ws.outbound)
+}
+
+// WriteMessage queue message to writer goroutine, slow consumer policy
+// applies when send queue is full
+func (ws *wsConn) WriteMessage(msg []byte) error {
+	select {
+	case <-ws.done:
+		return websocket.ErrCloseSent
+	case <-ws.writerDone:
+		return ws.writeErr
+	default:
+	}
+
+	select {
+	case ws.outbound <- msg:
+		return nil
+	default:
+	}
+	return ws.sendQueueFull(msg)
+}
+
+// reply send gateway response message of client request id
+func (ws *wsConn) reply(id string, message string) error {
+	frame, err := encodePushMessage(gatewayReply(id, message), ws.rawJSONMode)
+	if err != nil {
+		return err
+	}
+	return ws.WriteMessage(frame)
+}
+
+func (ws *wsConn) rawJSON() bool {
+	return ws.rawJSONMode
+}
+
+func (ws *wsConn) ID() string {
+	return ws.id
+}
+
 func (ws *wsConn) RemoteAddr() string {
-	return ws.conn.RemoteAddr().String()
+	return ws.remoteAddr
+}
+
+func (ws *wsConn) Close() error {
+	return ws.close(nil)
+}
+
+// closeWithCode flush queued messages, send close message and close connection
+func (ws *wsConn) closeWithCode(code int, reason string) error {
+	return ws.close(websocket.FormatCloseMessage(code, reason))
+}
+
+func (ws *wsConn) close(closeMsg []byte) error {
+	var err error
+	ws.closeOnce.Do(func() {
+		close(ws.done)
+		<-ws.writerDone
+		if closeMsg != nil {
+			ws.conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(ws.writeWait))
+		}
+		err = ws.conn.Close()
+	})
+	return err
 }
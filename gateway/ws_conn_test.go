@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestConcurrentPushAndSubscribe(t *testing.T) {
+	store := NewInMemeryWSClientStore()
+	gateway := NewGatewayServer(store, &FakeAuthServer{}, WithSendQueueSize(1024))
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	ws := mustConnectAndAuthAndSubscribe(t, server, 123456, "654321", "match")
+	defer ws.Close()
+
+	const pushCount = 500
+	const subscribeCount = 200
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < pushCount; i++ {
+			response := httptest.NewRecorder()
+			gateway.ServeHTTP(response, newPushMessagePostRequest("match", anonymousMemberID, `{"hello":"world"}`))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < subscribeCount; i++ {
+			if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"app":"im"}`)); err != nil {
+				t.Errorf("write subscribe message failed: %v", err)
+				return
+			}
+		}
+	}()
+
+	pushed, replies := 0, 0
+	for pushed < pushCount || replies < subscribeCount {
+		msg, err := readMessageWithTimeout(ws, time.Second)
+		if err != nil {
+			t.Fatalf("read message failed after %d pushes and %d replies: %v", pushed, replies, err)
+		}
+
+		var pushMsg PushMessage
+		if err := json.Unmarshal([]byte(msg), &pushMsg); err != nil {
+			t.Fatalf("got corrupted message %q", msg)
+		}
+		switch pushMsg.App {
+		case "match":
+			pushed++
+		case "gateway":
+			assertMessage(t, msg, subscribeSuccessMessageForApp(imApp))
+			replies++
+		}
+	}
+	wg.Wait()
+}
+
+func TestWSConnFlushOnClose(t *testing.T) {
+	store := NewInMemeryWSClientStore()
+	server := httptest.NewServer(NewGatewayServer(store, &FakeAuthServer{}))
+	defer server.Close()
+
+	ws, _ := mustConnectTo(t, server)
+	defer ws.Close()
+
+	mustWriteMessage(t, ws, "{}")
+	assertMessage(t, mustReadMessageWithTimeout(t, ws, time.Millisecond*100), missingAuthMessage())
+	_, err := readMessageWithTimeout(ws, time.Millisecond*100)
+	assertError(t, err)
+}
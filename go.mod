@@ -3,6 +3,11 @@ module github.com/mgxian/ws-gateway
 go 1.12
 
 require (
+	github.com/alicebob/miniredis/v2 v2.14.1
+	github.com/go-redis/redis/v7 v7.4.0
 	github.com/gorilla/websocket v1.4.1
+	github.com/nats-io/nats-server/v2 v2.1.9
+	github.com/nats-io/nats.go v1.11.0
 	github.com/orcaman/concurrent-map v0.0.0-20190826125027-8c72a8bb44f6
+	github.com/segmentio/kafka-go v0.4.10
 )
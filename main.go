@@ -1,14 +1,182 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/go-redis/redis/v7"
 	"github.com/mgxian/ws-gateway/gateway"
+	"github.com/nats-io/nats.go"
 )
 
+var (
+	wsAddr   = flag.String("ws-addr", ":5000", "listen address of public websocket connections")
+	pushAddr = flag.String("push-addr", "127.0.0.1:5001", "listen address of internal push api")
+	statAddr = flag.String("stat-addr", "127.0.0.1:6000", "listen address of stat server")
+
+	appsFile = flag.String("apps", "", "apps definition file, only im is private app when empty")
+
+	authType         = flag.String("auth", "http", "auth server type: http, jwt or fake which accepts almost every member and is only for development")
+	authURL          = flag.String("auth-url", "", "member service auth url for http auth server")
+	authTimeout      = flag.Duration("auth-timeout", time.Second*3, "member service auth request timeout")
+	authRetries      = flag.Int("auth-retries", 1, "member service auth request retries")
+	authCookie       = flag.String("auth-cookie", "ws_token", "cookie name of auth token in websocket upgrade request")
+	allowedOrigins   = flag.String("allowed-origins", "", "comma separated origins allowed to open websocket with auth cookie besides gateway origin")
+	authExpiredClose = flag.Bool("auth-expired-close", false, "close connection when auth expired instead of dropping private subscriptions")
+	authFailOpen     = flag.Bool("auth-fail-open", false, "treat member as authed when member service is not available")
+
+	pingInterval = flag.Duration("ping-interval", time.Second*30, "websocket ping interval, 0 disables heartbeat")
+	pongWait     = flag.Duration("pong-wait", time.Second*60, "close connection which sends nothing in pong wait")
+
+	sendQueueSize      = flag.Int("send-queue-size", 256, "outbound message queue size of each websocket connection")
+	writeTimeout       = flag.Duration("write-timeout", time.Second*10, "write deadline of each websocket message")
+	fanoutWorkers      = flag.Int("fanout-workers", runtime.NumCPU(), "count of workers delivering push messages to connections")
+	slowConsumerPolicy = flag.String("slow-consumer-policy", "drop-oldest", "policy when send queue is full: drop-oldest, drop-newest or disconnect")
+
+	drainWindow     = flag.Duration("drain-window", time.Second*10, "window of closing connections in batches on shutdown")
+	drainBatchSize  = flag.Int("drain-batch-size", 100, "count of connections closed in each batch on shutdown")
+	shutdownTimeout = flag.Duration("shutdown-timeout", time.Second*10, "extra time of shutdown after drain window, connections left are closed at once")
+
+	pushKeysFile     = flag.String("push-keys", "", "push api keys file, push api is not authenticated when empty")
+	pushMaxClockSkew = flag.Duration("push-max-clock-skew", time.Minute*5, "max allowed clock skew of signed push request timestamp")
+	pushQueueSize    = flag.Int("push-queue-size", 1000, "push queue size")
+	pushQueuePolicy  = flag.String("push-queue-policy", "block", "policy when push queue is full: block, reject or spill")
+	pushBlockTimeout = flag.Duration("push-block-timeout", time.Second*5, "max wait of push request for queue space with block policy")
+	pushRetryAfter   = flag.Duration("push-retry-after", time.Second, "Retry-After of rejected push request")
+	pushSpoolDir     = flag.String("push-spool-dir", "spool", "disk spool dir of spill policy")
+
+	redisAddr     = flag.String("redis-addr", "", "redis address of cluster mode, gateway runs standalone when empty")
+	redisPassword = flag.String("redis-password", "", "redis password of cluster mode")
+	redisDB       = flag.Int("redis-db", 0, "redis db of cluster mode")
+	redisChannel  = flag.String("redis-channel", gateway.DefaultRedisBusChannel, "redis channel push messages are published to in cluster mode")
+	natsURL       = flag.String("nats-url", "", "nats url of cluster mode, push messages are published to nats instead of redis when set")
+	natsSubject   = flag.String("nats-subject", gateway.DefaultNATSBusSubject, "nats subject push messages are published under in cluster mode")
+	nodeID        = flag.String("node-id", defaultNodeID(), "unique id of gateway node in cluster mode")
+	presenceTTL   = flag.Duration("presence-ttl", time.Second*30, "lease ttl of members connected to node in cluster mode")
+
+	kafkaBrokers = flag.String("kafka-brokers", "", "comma separated kafka brokers, kafka is not consumed when empty")
+	kafkaGroup   = flag.String("kafka-group", "ws-gateway", "kafka consumer group")
+	kafkaTopics  = flag.String("kafka-topics", "", "comma separated topic=app mappings of kafka topics consumed as pushes")
+
+	jwtHMACSecretFile     = flag.String("jwt-hmac-secret-file", "", "HS256 secret file for jwt auth server")
+	jwtRSAPublicKeyFile   = flag.String("jwt-rsa-public-key-file", "", "RS256 PEM public key file for jwt auth server")
+	jwtECDSAPublicKeyFile = flag.String("jwt-ecdsa-public-key-file", "", "ES256 PEM public key file for jwt auth server")
+	jwtJWKSFile           = flag.String("jwt-jwks-file", "", "JWKS file for jwt auth server")
+	jwtAudience           = flag.String("jwt-audience", "", "required jwt aud claim")
+	jwtIssuer             = flag.String("jwt-issuer", "", "required jwt iss claim")
+	jwtAppsClaim          = flag.String("jwt-apps-claim", "apps", "jwt claim of apps member can subscribe")
+)
+
+func newAuthServer() gateway.AuthServer {
+	switch *authType {
+	case "fake":
+		log.Println("WARNING: fake auth server accepts almost every member, never use it in production")
+		return &gateway.FakeAuthServer{}
+	case "http":
+		if *authURL == "" {
+			log.Fatal("auth-url is required for http auth server, use -auth fake for development")
+		}
+		return gateway.NewHTTPAuthServer(gateway.HTTPAuthServerConfig{
+			URL:      *authURL,
+			Timeout:  *authTimeout,
+			Retries:  *authRetries,
+			FailOpen: *authFailOpen,
+		})
+	case "jwt":
+		authServer, err := gateway.NewJWTAuthServer(gateway.JWTAuthServerConfig{
+			HMACSecretFile:     *jwtHMACSecretFile,
+			RSAPublicKeyFile:   *jwtRSAPublicKeyFile,
+			ECDSAPublicKeyFile: *jwtECDSAPublicKeyFile,
+			JWKSFile:           *jwtJWKSFile,
+			Audience:           *jwtAudience,
+			Issuer:             *jwtIssuer,
+			AppsClaim:          *jwtAppsClaim,
+		})
+		if err != nil {
+			log.Fatalf("create jwt auth server failed: %v", err)
+		}
+		return authServer
+	}
+	log.Fatalf("unknown auth server type %q", *authType)
+	return nil
+}
+
+func newPushQueueConfig() (gateway.PushQueueConfig, error) {
+	policy, err := gateway.ParsePushQueuePolicy(*pushQueuePolicy)
+	if err != nil {
+		return gateway.PushQueueConfig{}, err
+	}
+
+	config := gateway.PushQueueConfig{
+		Size:         *pushQueueSize,
+		Policy:       policy,
+		BlockTimeout: *pushBlockTimeout,
+		RetryAfter:   *pushRetryAfter,
+	}
+	if policy == gateway.PushQueueSpill {
+		if config.Spool, err = gateway.NewPushSpool(*pushSpoolDir); err != nil {
+			return gateway.PushQueueConfig{}, err
+		}
+	}
+	return config, nil
+}
+
+func defaultNodeID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "gateway"
+	}
+	return hostname
+}
+
+func newRedisClient() (*redis.Client, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     *redisAddr,
+		Password: *redisPassword,
+		DB:       *redisDB,
+	})
+	if err := client.Ping().Err(); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func parseKafkaTopics(mappings string) (map[string]string, error) {
+	topics := make(map[string]string)
+	for _, mapping := range strings.Split(mappings, ",") {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("bad kafka topic mapping %q, want topic=app", mapping)
+		}
+		topics[parts[0]] = parts[1]
+	}
+	return topics, nil
+}
+
+func newKafkaConsumer(server *gateway.Server) (*gateway.KafkaConsumer, error) {
+	topics, err := parseKafkaTopics(*kafkaTopics)
+	if err != nil {
+		return nil, err
+	}
+	brokers := strings.Split(*kafkaBrokers, ",")
+	return gateway.NewKafkaConsumer(server, gateway.KafkaConsumerConfig{
+		Topics: topics,
+		NewReader: func(topic string) (gateway.KafkaReader, error) {
+			return gateway.NewKafkaGoReader(brokers, *kafkaGroup, topic), nil
+		},
+	}), nil
+}
+
 func main() {
 	debugEnabled := flag.Bool("debug", false, "pprof debug mode")
 
@@ -20,14 +188,116 @@ func main() {
 		}()
 	}
 
-	authServer := &gateway.FakeAuthServer{}
-	store := gateway.NewInMemeryWSClientStore()
-	server := gateway.NewGatewayServer(store, authServer)
-	statServer := gateway.NewStatServer(store)
+	apps := gateway.NewAppRegistry(gateway.AppConfig{Name: "im", Visibility: gateway.PrivateApp})
+	if *appsFile != "" {
+		configs, err := gateway.LoadApps(*appsFile)
+		if err != nil {
+			log.Fatalf("load apps failed: %v", err)
+		}
+		apps.Register(configs...)
+	}
+
+	policy, err := gateway.ParseSlowConsumerPolicy(*slowConsumerPolicy)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pushQueue, err := newPushQueueConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authServer := newAuthServer()
+	store := gateway.NewInMemeryWSClientStoreForApps(apps)
+	opts := []gateway.ServerOption{
+		gateway.WithAppRegistry(apps),
+		gateway.WithAuthCookie(*authCookie),
+		gateway.WithHeartbeat(*pingInterval, *pongWait),
+		gateway.WithSendQueueSize(*sendQueueSize),
+		gateway.WithWriteTimeout(*writeTimeout),
+		gateway.WithSlowConsumerPolicy(policy),
+		gateway.WithFanoutWorkers(*fanoutWorkers),
+		gateway.WithPushQueue(pushQueue),
+		gateway.WithDrainWindow(*drainWindow, *drainBatchSize),
+	}
+	if *allowedOrigins != "" {
+		opts = append(opts, gateway.WithAllowedOrigins(strings.Split(*allowedOrigins, ",")...))
+	}
+	if *authExpiredClose {
+		opts = append(opts, gateway.WithCloseOnAuthExpired())
+	}
+	if *pushKeysFile != "" {
+		pushAuth, err := gateway.LoadPushAuth(*pushKeysFile, *pushMaxClockSkew)
+		if err != nil {
+			log.Fatalf("load push keys failed: %v", err)
+		}
+		opts = append(opts, gateway.WithPushAuth(pushAuth))
+	}
+	if *redisAddr != "" {
+		client, err := newRedisClient()
+		if err != nil {
+			log.Fatalf("connect redis failed: %v", err)
+		}
+		opts = append(opts,
+			gateway.WithMessageBus(gateway.NewRedisBus(client, *redisChannel)),
+			gateway.WithPresence(gateway.NewRedisPresence(client, ""), *nodeID, *presenceTTL),
+		)
+	}
+	if *natsURL != "" {
+		conn, err := nats.Connect(*natsURL, nats.Name(*nodeID))
+		if err != nil {
+			log.Fatalf("connect nats failed: %v", err)
+		}
+		defer conn.Close()
+		opts = append(opts, gateway.WithMessageBus(gateway.NewNATSBus(conn, *natsSubject)))
+	}
+	server := gateway.NewGatewayServer(store, authServer, opts...)
+	statServer := gateway.NewStatServer(store, server)
+
+	consumeCtx, stopConsume := context.WithCancel(context.Background())
+	defer stopConsume()
+	if *kafkaBrokers != "" {
+		consumer, err := newKafkaConsumer(server)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			if err := consumer.Run(consumeCtx); err != nil {
+				log.Printf("kafka consumer stopped: %v", err)
+			}
+		}()
+	}
+
+	statHTTPServer := &http.Server{Addr: *statAddr, Handler: statServer}
+	pushHTTPServer := &http.Server{Addr: *pushAddr, Handler: server.PushHandler()}
+	wsHTTPServer := &http.Server{Addr: *wsAddr, Handler: server.WebSocketHandler()}
+
+	go func() {
+		log.Println(statHTTPServer.ListenAndServe())
+	}()
+
+	go func() {
+		if err := pushHTTPServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("could not listen on %s %v", *pushAddr, err)
+		}
+	}()
+
+	go func() {
+		if err := wsHTTPServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("could not listen on %s %v", *wsAddr, err)
+		}
+	}()
 
-	go http.ListenAndServe("127.0.0.1:6000", statServer)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	log.Printf("received signal %v, shutting down", <-signals)
 
-	if err := http.ListenAndServe(":5000", server); err != nil {
-		log.Fatalf("could not listen on port 5000 %v", err)
+	ctx, cancel := context.WithTimeout(context.Background(), *drainWindow+*shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("shutdown gateway server failed: %v", err)
 	}
+	wsHTTPServer.Shutdown(ctx)
+	pushHTTPServer.Shutdown(ctx)
+	statHTTPServer.Shutdown(ctx)
 }